@@ -59,7 +59,9 @@ require (
 	github.com/rancher/wrangler-api v0.6.0
 	github.com/sirupsen/logrus v1.8.1
 	github.com/urfave/cli v1.22.2
-	gopkg.in/yaml.v3 v3.0.0-20210107192922-496545a6307b // indirect
+	go.etcd.io/bbolt v1.3.5
+	go.etcd.io/etcd v0.5.0-alpha.5.0.20201208200253-50621aee4aea
+	gopkg.in/yaml.v3 v3.0.0-20210107192922-496545a6307b
 	k8s.io/api v1.20.5
 	k8s.io/apimachinery v1.20.5
 	k8s.io/apiserver v1.20.0 // indirect
@@ -144,6 +144,76 @@ func main() {
 			Usage:       "Disable migrating CNI configuration",
 			Destination: &config.DisableCNIMigrate,
 		},
+		&cli.StringFlag{
+			Name:        "flannel-target",
+			Usage:       "RKE2 CNI used to replace the RKE1 flannel network plugin, either canal or none to keep flannel running",
+			Destination: &config.FlannelTarget,
+			Value:       "canal",
+		},
+		&cli.StringFlag{
+			Name:        "rke2-version",
+			Usage:       "RKE2 version used to restore the snapshot, defaults to the version of the installed RKE2 binary",
+			EnvVar:      "RKE2_VERSION",
+			Destination: &config.RKE2Version,
+		},
+		&cli.StringFlag{
+			Name:        "addons-removal-image",
+			Usage:       "Image of the job removing the RKE1 addons, it must ship sh, kubectl and helm, defaults to rancher/klipper-helm from the system default registry",
+			Destination: &config.AddonsRemovalImage,
+		},
+		&cli.StringSliceFlag{
+			Name:  "addons-removal-pull-secret",
+			Usage: "Image pull secret of the job removing the RKE1 addons",
+			Value: &config.AddonsRemovalPullSecrets,
+		},
+		&cli.StringSliceFlag{
+			Name:  "remove-addons",
+			Usage: "RKE1 addons removed after the migration, one of network, coredns, ingress or metrics, defaults to all of them",
+			Value: &config.RemoveAddons,
+		},
+		&cli.StringFlag{
+			Name:        "cluster-dir",
+			Usage:       "Directory of the RKE cluster.yml, relative addons_include paths are resolved from it",
+			Destination: &config.ClusterDir,
+			Value:       ".",
+		},
+		&cli.StringFlag{
+			Name:        "addons-include-ca",
+			Usage:       "CA cert to verify the HTTPS urls of addons_include",
+			Destination: &config.AddonsIncludeCA,
+		},
+		&cli.StringSliceFlag{
+			Name:  "prune",
+			Usage: "RKE1 objects removed from the restored etcd data, one of deploy-jobs, cluster-state or node-annotations",
+			Value: &config.Prune,
+		},
+		&cli.StringSliceFlag{
+			Name:  "prune-node",
+			Usage: "Node that is not migrated and is removed from the restored etcd data",
+			Value: &config.PruneNodes,
+		},
+		&cli.StringSliceFlag{
+			Name:  "skip-preflight",
+			Usage: "Preflight check skipped before the migration, one of disk-space, rke2-data, rke2-process, ports, swap, cgroups, kernel-modules, sysctls or etc-rancher",
+			Value: &config.SkipPreflight,
+		},
+		&cli.BoolFlag{
+			Name:        "stop-rke-containers",
+			Usage:       "Stop the RKE containers running on the node and disable their restart, the migration is refused while they are running otherwise",
+			Destination: &config.StopRKEContainers,
+		},
+	}
+	app.Commands = []cli.Command{
+		{
+			Name:   "inspect",
+			Usage:  "Print the RKE cluster details of the snapshot, the recommended RKE2 version and the objects incompatible with it",
+			Action: inspect,
+		},
+		{
+			Name:   "preflight",
+			Usage:  "Check that the node is ready to be migrated to RKE2 without changing it",
+			Action: preflightCheck,
+		},
 	}
 	app.Action = run
 	if err := app.Run(os.Args); err != nil {
@@ -151,6 +221,16 @@ func main() {
 	}
 }
 
+func inspect(c *cli.Context) error {
+	ctx := signals.SetupSignalHandler(context.Background())
+	return migrate.Inspect(ctx, &config, os.Stdout)
+}
+
+func preflightCheck(c *cli.Context) error {
+	ctx := signals.SetupSignalHandler(context.Background())
+	return migrate.Preflight(ctx, &config, os.Stdout)
+}
+
 func run(c *cli.Context) {
 	// set up logging to disk
 	if err := os.MkdirAll(filepath.Dir(logFile), 0755); err != nil {
@@ -17,6 +17,8 @@ const (
 	keyType
 )
 
+const serviceCertName = "service.crt"
+
 func RecoverCertsFromState(ctx context.Context, config *config.Control, state *cluster.FullState) error {
 	logrus.Infof("Migrating CA certificates from RKE state file")
 	if err := setCertsAndDirs(config); err != nil {
@@ -99,6 +101,12 @@ func writeCertBundle(runtime *config.ControlRuntime, certBundle map[string]pki.C
 				currentCert, keyType, runtime.ControlRuntimeBootstrap.ServiceKey); err != nil {
 				return err
 			}
+			// RKE2 only uses the key, the certificate is kept for RKE args
+			// pointing to it
+			if err := writeFile(
+				currentCert, certType, filepath.Join(filepath.Dir(runtime.ControlRuntimeBootstrap.ServiceKey), serviceCertName)); err != nil {
+				return err
+			}
 		}
 	}
 	return nil
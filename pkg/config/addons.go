@@ -2,9 +2,21 @@ package config
 
 import (
 	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
 	"fmt"
+	"io"
+	"net/http"
 	"os"
+	"path"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/rancher/rke/cluster"
 	"github.com/rancher/rke/types"
@@ -14,8 +26,11 @@ import (
 	batch "k8s.io/api/batch/v1"
 	core "k8s.io/api/core/v1"
 	rbac "k8s.io/api/rbac/v1"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
 	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/version"
 	"sigs.k8s.io/yaml"
 )
 
@@ -26,19 +41,207 @@ const (
 	networkConfigMap           = "rke-network-plugin"
 	userAddonsConfigMap        = "rke2-user-addons"
 	userAddonsIncludeConfigMap = "rke-user-includes-addons"
+
+	noneProvider    = "none"
+	kubeDNSProvider = "kube-dns"
+
+	defaultIngressClass           = "nginx"
+	ingressNetworkModeHostPort    = "hostPort"
+	ingressNetworkModeHostNetwork = "hostNetwork"
+	// RKE deploys the ingress controller with host ports by default starting
+	// with kubernetes v1.21 and with host network before
+	ingressHostPortDefaultMinor = 21
+
+	removalJobName = "migration-agent-addons-remove"
+	// the job runs shell scripts with kubectl and helm, both shipped with sh in
+	// the helm image of the rke2 helm controller
+	defaultRemovalJobImage = "rancher/klipper-helm:v0.8.0-build20230510"
+	// handoverTimeoutSeconds bounds the wait for the rke2 chart release
+	handoverTimeoutSeconds = 1800
 )
 
+var invalidManifestNameChars = regexp.MustCompile(`[^a-z0-9.]+`)
+
+// crdReleases are the rke2 releases installing the CRDs of the RKE1 addons, the
+// canal chart renders the calico CRDs itself while the calico chart has a
+// separate CRD chart. The CRDs of the other releases are left in place.
+var crdReleases = map[string]string{
+	"rke2-calico": "rke2-calico-crd",
+	"rke2-canal":  "rke2-canal",
+}
+
+// cleanupScript removes the removal job and its service account and rbac once
+// the addons are deleted. The job loses its permissions as soon as one of them
+// is deleted, so they are made dependents of the ClusterRole and removed by the
+// garbage collector when the ClusterRole is deleted.
+const cleanupScript = `set -e
+uid=$(kubectl get clusterrole ` + removalJobName + ` -o jsonpath='{.metadata.uid}')
+owner='{"metadata":{"ownerReferences":[{"apiVersion":"rbac.authorization.k8s.io/v1","kind":"ClusterRole","name":"` + removalJobName + `","uid":"'"$uid"'"}]}}'
+kubectl patch clusterrolebinding ` + removalJobName + ` -p "$owner"
+kubectl -n kube-system patch serviceaccount ` + removalJobName + ` -p "$owner"
+kubectl -n kube-system patch job ` + removalJobName + ` -p "$owner"
+kubectl delete clusterrole ` + removalJobName + ` --wait=false
+`
+
+// rkeAddon is an RKE1 system addon deployed from a ConfigMap, the removal job
+// deletes the addon using the manifest stored in the ConfigMap.
+type rkeAddon struct {
+	name      string
+	configMap string
+	volume    string
+	container string
+	// rules allow the removal job to hand over or delete the objects of the
+	// addon manifest
+	rules []rbac.PolicyRule
+	// release is the rke2 chart release taking over the addon objects
+	release string
+}
+
+var rkeAddons = []rkeAddon{
+	{
+		name:      "network",
+		configMap: networkConfigMap,
+		volume:    "network-config",
+		container: "network-addons-remove",
+		rules: []rbac.PolicyRule{
+			addonRule("", "configmaps", "serviceaccounts", "secrets"),
+			addonRule("apps", "daemonsets", "deployments"),
+			addonRule("rbac.authorization.k8s.io", "clusterroles", "clusterrolebindings", "roles", "rolebindings"),
+			addonRule("apiextensions.k8s.io", "customresourcedefinitions"),
+			addonRule("policy", "podsecuritypolicies", "poddisruptionbudgets"),
+		},
+	},
+	{
+		name:      "coredns",
+		configMap: corednsConfigMap,
+		volume:    "coredns-config",
+		container: "dns-addons-remove",
+		rules: []rbac.PolicyRule{
+			addonRule("", "configmaps", "serviceaccounts", "services"),
+			addonRule("apps", "daemonsets", "deployments"),
+			addonRule("rbac.authorization.k8s.io", "clusterroles", "clusterrolebindings"),
+		},
+	},
+	{
+		name:      "ingress",
+		configMap: ingrerssConfigMap,
+		volume:    "ingress-config",
+		container: "ingress-addons-remove",
+		rules: []rbac.PolicyRule{
+			addonRule("", "namespaces", "configmaps", "serviceaccounts", "services"),
+			addonRule("apps", "daemonsets", "deployments"),
+			addonRule("batch", "jobs"),
+			addonRule("rbac.authorization.k8s.io", "clusterroles", "clusterrolebindings", "roles", "rolebindings"),
+			addonRule("networking.k8s.io", "ingressclasses"),
+			addonRule("admissionregistration.k8s.io", "validatingwebhookconfigurations"),
+			addonRule("policy", "podsecuritypolicies"),
+		},
+	},
+	{
+		name:      "metrics",
+		configMap: metricsConfigMap,
+		volume:    "metrics-config",
+		container: "metrics-addons-remove",
+		rules: []rbac.PolicyRule{
+			addonRule("", "serviceaccounts", "services"),
+			addonRule("apps", "deployments"),
+			addonRule("rbac.authorization.k8s.io", "clusterroles", "clusterrolebindings", "rolebindings"),
+			addonRule("apiregistration.k8s.io", "apiservices"),
+		},
+	},
+}
+
+// ValidateRemovedAddons checks the addon groups selected for removal.
+func ValidateRemovedAddons(addons []string) error {
+	for _, name := range addons {
+		found := false
+		for _, addon := range rkeAddons {
+			if addon.name == name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("invalid addon %s to remove, should be one of network, coredns, ingress or metrics", name)
+		}
+	}
+	return nil
+}
+
+// disabledComponents returns the RKE2 packaged components that should be
+// disabled because their RKE1 counterpart was not deployed.
+func disabledComponents(rkeConfig *types.RancherKubernetesEngineConfig) []string {
+	disabled := []string{}
+	if rkeConfig.Ingress.Provider == noneProvider {
+		logrus.Infof("Ingress provider is set to none in RKE state, disabling rke2-%s", nginxIngress)
+		disabled = append(disabled, "rke2-"+nginxIngress)
+	}
+	if rkeConfig.DNS != nil {
+		switch rkeConfig.DNS.Provider {
+		case noneProvider:
+			logrus.Infof("DNS provider is set to none in RKE state, disabling rke2-%s", coredns)
+			disabled = append(disabled, "rke2-"+coredns)
+		case kubeDNSProvider:
+			logrus.Warnf("DNS provider kube-dns is not packaged by RKE2, disabling rke2-%s and keeping the RKE1 kube-dns deployment", coredns)
+			disabled = append(disabled, "rke2-"+coredns)
+		}
+	}
+	if rkeConfig.Monitoring.Provider == noneProvider {
+		logrus.Infof("Monitoring provider is set to none in RKE state, disabling rke2-%s", metricsServer)
+		disabled = append(disabled, "rke2-"+metricsServer)
+	}
+	return disabled
+}
+
 func manifestsDir(dataDir string) string {
 	return filepath.Join(dataDir, "server", "manifests")
 }
 
-func RemoveOldAddons(ctx context.Context, dataDir string) error {
-	objs := []runtime.Object{}
-	crb := roleBinding()
-	removalJob := job()
-	sa := serviceAccount()
+// RemoveOldAddons writes the job handing the RKE1 addons selected by the
+// operator over to the rke2 charts, all addons are handed over if none is
+// selected. The job image defaults to the helm image from the system default
+// registry, and the job is allowed to handle the kinds found in the addon
+// ConfigMaps on top of the kinds deployed by RKE1.
+func RemoveOldAddons(ctx context.Context, fullState *cluster.FullState, dataDir, flannelTarget, image string, pullSecrets, removedAddons []string, configMap v1.ConfigMapController) error {
+	addons := []rkeAddon{}
+	rkeConfig := fullState.CurrentState.RancherKubernetesEngineConfig
+	for _, addon := range rkeAddons {
+		if len(removedAddons) > 0 && !containsString(removedAddons, addon.name) {
+			logrus.Infof("Keeping RKE1 %s addon, it is not selected for removal", addon.name)
+			continue
+		}
+		release, ok := addonRelease(addon, rkeConfig, flannelTarget)
+		if !ok {
+			continue
+		}
+		addon.release = release
+		if configMap != nil {
+			addonConfigMap, err := configMap.Get("kube-system", addon.configMap, meta.GetOptions{})
+			if err != nil {
+				logrus.Warnf("Failed to read the %s ConfigMap, the removal job may lack permissions for objects added to the %s addon: %v", addon.configMap, addon.name, err)
+			} else {
+				addon.rules = append(append([]rbac.PolicyRule{}, addon.rules...), manifestRules(addonConfigMap.Data[addon.configMap])...)
+			}
+		}
+		addons = append(addons, addon)
+	}
+	if len(addons) == 0 {
+		return nil
+	}
 
-	objs = append(objs, crb, sa, removalJob)
+	if image == "" {
+		image = defaultRemovalJobImage
+		if registry := chartsRegistry(fullState.CurrentState.RancherKubernetesEngineConfig); registry != "" {
+			image = registry + "/" + image
+		}
+	}
+
+	objs := []runtime.Object{
+		clusterRole(addons),
+		roleBinding(),
+		serviceAccount(),
+		job(addons, image, pullSecrets),
+	}
 	yamlContent, err := objectsToYaml(objs)
 	if err != nil {
 		return err
@@ -53,133 +256,186 @@ func RemoveOldAddons(ctx context.Context, dataDir string) error {
 	return os.WriteFile(manifestFile, []byte(yamlContent), 0600)
 }
 
-func job() *batch.Job {
+// addonRelease returns the rke2 chart release replacing the RKE1 addon, false
+// is returned if the addon was not deployed by RKE1 or is kept running.
+func addonRelease(addon rkeAddon, rkeConfig *types.RancherKubernetesEngineConfig, flannelTarget string) (string, bool) {
+	switch addon.configMap {
+	case networkConfigMap:
+		plugin := rkeConfig.Network.Plugin
+		if plugin == "" || plugin == noneCNI {
+			return "", false
+		}
+		if KeepNetworkAddon(plugin, flannelTarget) {
+			logrus.Infof("Keeping RKE1 %s network plugin, it will not be removed by the addons removal job", plugin)
+			return "", false
+		}
+		return "rke2-" + TargetCNI(plugin, flannelTarget), true
+	case corednsConfigMap:
+		if rkeConfig.DNS != nil && rkeConfig.DNS.Provider != coredns {
+			return "", false
+		}
+		return "rke2-" + coredns, true
+	case ingrerssConfigMap:
+		if rkeConfig.Ingress.Provider != "nginx" {
+			return "", false
+		}
+		return "rke2-" + nginxIngress, true
+	case metricsConfigMap:
+		if rkeConfig.Monitoring.Provider != metricsServer {
+			return "", false
+		}
+		return "rke2-" + metricsServer, true
+	}
+	return "", false
+}
+
+// handoverScript hands the objects of the RKE1 addon manifest over to the rke2
+// chart release so that helm adopts the objects rendered by the chart instead of
+// failing on them, the RKE1 workloads keep running until the release is
+// installed. Once installed, the objects missing from the manifests of the
+// releases are deleted. CRDs are handed over to the release installing the CRDs
+// of the chart and never deleted, deleting them removes every custom resource
+// of the cluster, the ones no release adopted only lose the helm ownership.
+func handoverScript(manifest, release string) string {
+	return fmt.Sprintf(`set -e
+manifest=%s
+release=%s
+crd_release=%s
+timeout=%d
+columns='KIND:.kind,NAMESPACE:.metadata.namespace,NAME:.metadata.name'
+objects() {
+  kubectl get -f "$1" --namespace=kube-system --ignore-not-found --no-headers -o custom-columns="$columns" |
+    while read kind namespace name; do echo "$kind $namespace $name"; done
+}
+ns() {
+  if [ "$1" != "<none>" ]; then echo "--namespace=$1"; fi
+}
+release_of() {
+  case "$1" in
+  CustomResourceDefinition) echo "$crd_release" ;;
+  *) echo "$release" ;;
+  esac
+}
+wait_release() {
+  elapsed=0
+  until [ "$elapsed" -ge "$timeout" ]; do
+    conditions=$(kubectl -n kube-system get job "helm-install-$1" --ignore-not-found \
+      -o jsonpath='{.status.conditions[?(@.status=="True")].type}')
+    case "$conditions" in
+    *Complete*) return 0 ;;
+    *Failed*) echo "helm-install-$1 failed" >&2; exit 1 ;;
+    esac
+    sleep 10
+    elapsed=$((elapsed + 10))
+  done
+  echo "timed out waiting for helm-install-$1" >&2
+  exit 1
+}
+objects "$manifest" > /tmp/addon-objects
+while read kind namespace name; do
+  object_release=$(release_of "$kind")
+  if [ -z "$object_release" ]; then continue; fi
+  kubectl annotate --overwrite $(ns "$namespace") "$kind" "$name" \
+    meta.helm.sh/release-name="$object_release" meta.helm.sh/release-namespace=kube-system
+  kubectl label --overwrite $(ns "$namespace") "$kind" "$name" app.kubernetes.io/managed-by=Helm
+done < /tmp/addon-objects
+releases="$release"
+if [ -n "$crd_release" ] && [ "$crd_release" != "$release" ]; then releases="$release $crd_release"; fi
+: > /tmp/release-objects
+for r in $releases; do
+  wait_release "$r"
+  helm get manifest "$r" --namespace=kube-system > /tmp/release.yaml
+  if [ -s /tmp/release.yaml ]; then objects /tmp/release.yaml >> /tmp/release-objects; fi
+done
+objects "$manifest" > /tmp/addon-objects
+while read kind namespace name; do
+  if grep -qxF "$kind $namespace $name" /tmp/release-objects; then
+    echo "$kind $name adopted by $(release_of "$kind")"
+  elif [ "$kind" = "CustomResourceDefinition" ]; then
+    echo "$kind $name is not part of a release, keeping it"
+    if [ -n "$crd_release" ]; then
+      kubectl annotate $(ns "$namespace") "$kind" "$name" meta.helm.sh/release-name- meta.helm.sh/release-namespace-
+      kubectl label $(ns "$namespace") "$kind" "$name" app.kubernetes.io/managed-by-
+    fi
+  else
+    kubectl delete --ignore-not-found $(ns "$namespace") "$kind" "$name"
+  fi
+done < /tmp/addon-objects
+`, manifest, release, crdReleases[release], handoverTimeoutSeconds)
+}
+
+func job(addons []rkeAddon, image string, pullSecrets []string) *batch.Job {
 	backoffLimit := int32(20)
+	ttlSecondsAfterFinished := int32(600)
 	job := &batch.Job{
 		TypeMeta: meta.TypeMeta{
 			APIVersion: "batch/v1",
 			Kind:       "Job",
 		},
 		ObjectMeta: meta.ObjectMeta{
-			Name:      "migration-agent-addons-remove",
+			Name:      removalJobName,
 			Namespace: "kube-system",
 		},
 		Spec: batch.JobSpec{
-			BackoffLimit: &backoffLimit,
+			BackoffLimit:            &backoffLimit,
+			TTLSecondsAfterFinished: &ttlSecondsAfterFinished,
 			Template: core.PodTemplateSpec{
 				ObjectMeta: meta.ObjectMeta{
 					Annotations: map[string]string{},
 				},
 				Spec: core.PodSpec{
-					Volumes: []core.Volume{
-						{
-							Name: "network-config",
-							VolumeSource: core.VolumeSource{
-								ConfigMap: &core.ConfigMapVolumeSource{
-									LocalObjectReference: core.LocalObjectReference{
-										Name: networkConfigMap,
-									},
-								},
-							},
-						},
-						{
-							Name: "metrics-config",
-							VolumeSource: core.VolumeSource{
-								ConfigMap: &core.ConfigMapVolumeSource{
-									LocalObjectReference: core.LocalObjectReference{
-										Name: metricsConfigMap,
-									},
-								},
-							},
-						},
-						{
-							Name: "coredns-config",
-							VolumeSource: core.VolumeSource{
-								ConfigMap: &core.ConfigMapVolumeSource{
-									LocalObjectReference: core.LocalObjectReference{
-										Name: corednsConfigMap,
-									},
-								},
-							},
-						},
-						{
-							Name: "ingress-config",
-							VolumeSource: core.VolumeSource{
-								ConfigMap: &core.ConfigMapVolumeSource{
-									LocalObjectReference: core.LocalObjectReference{
-										Name: ingrerssConfigMap,
-									},
-								},
-							},
-						},
-					},
-					RestartPolicy: core.RestartPolicyOnFailure,
-					Containers: []core.Container{
-						{
-							Name:            "network-addons-remove",
-							Image:           "bitnami/kubectl:latest",
-							ImagePullPolicy: core.PullIfNotPresent,
-							Command: []string{
-								"sh",
-								"-c",
-								"kubectl delete -f /etc/rke_addon/network/" + networkConfigMap},
-							VolumeMounts: []core.VolumeMount{
-								{
-									Name:      "network-config",
-									MountPath: "/etc/rke_addon/network",
-								},
-							},
-						},
-
-						{
-							Name:            "dns-addons-remove",
-							Image:           "bitnami/kubectl:latest",
-							ImagePullPolicy: core.PullIfNotPresent,
-							Command: []string{
-								"sh",
-								"-c",
-								"kubectl delete -f /etc/rke_addon/coredns/" + corednsConfigMap},
-							VolumeMounts: []core.VolumeMount{
-								{
-									Name:      "coredns-config",
-									MountPath: "/etc/rke_addon/coredns",
-								},
-							},
-						},
-						{
-							Name:            "ingress-addons-remove",
-							Image:           "bitnami/kubectl:latest",
-							ImagePullPolicy: core.PullIfNotPresent,
-							Command: []string{
-								"sh",
-								"-c",
-								"kubectl delete -f /etc/rke_addon/ingress/" + ingrerssConfigMap},
-							VolumeMounts: []core.VolumeMount{
-								{
-									Name:      "ingress-config",
-									MountPath: "/etc/rke_addon/ingress",
-								},
-							},
-						},
-						{
-							Name:            "metrics-addons-remove",
-							Image:           "bitnami/kubectl:latest",
-							ImagePullPolicy: core.PullIfNotPresent,
-							Command: []string{
-								"sh",
-								"-c",
-								"kubectl delete -f /etc/rke_addon/metrics/" + metricsConfigMap},
-							VolumeMounts: []core.VolumeMount{
-								{
-									Name:      "metrics-config",
-									MountPath: "/etc/rke_addon/metrics",
-								},
-							},
-						},
+					RestartPolicy:      core.RestartPolicyOnFailure,
+					ServiceAccountName: removalJobName,
+				},
+			},
+		},
+	}
+	for _, secret := range pullSecrets {
+		job.Spec.Template.Spec.ImagePullSecrets = append(job.Spec.Template.Spec.ImagePullSecrets, core.LocalObjectReference{
+			Name: secret,
+		})
+	}
+
+	// addons are handed over one after the other by init containers so the
+	// cleanup container only runs once all of them are done
+	for _, addon := range addons {
+		mountPath := "/etc/rke_addon/" + addon.name
+		job.Spec.Template.Spec.Volumes = append(job.Spec.Template.Spec.Volumes, core.Volume{
+			Name: addon.volume,
+			VolumeSource: core.VolumeSource{
+				ConfigMap: &core.ConfigMapVolumeSource{
+					LocalObjectReference: core.LocalObjectReference{
+						Name: addon.configMap,
 					},
-					ServiceAccountName: "migration-agent",
 				},
 			},
+		})
+		job.Spec.Template.Spec.InitContainers = append(job.Spec.Template.Spec.InitContainers, core.Container{
+			Name:            addon.container,
+			Image:           image,
+			ImagePullPolicy: core.PullIfNotPresent,
+			Command: []string{
+				"sh",
+				"-c",
+				handoverScript(mountPath+"/"+addon.configMap, addon.release)},
+			VolumeMounts: []core.VolumeMount{
+				{
+					Name:      addon.volume,
+					MountPath: mountPath,
+				},
+			},
+		})
+	}
+	job.Spec.Template.Spec.Containers = []core.Container{
+		{
+			Name:            "cleanup",
+			Image:           image,
+			ImagePullPolicy: core.PullIfNotPresent,
+			Command: []string{
+				"sh",
+				"-c",
+				cleanupScript,
+			},
 		},
 	}
 
@@ -221,6 +477,126 @@ func job() *batch.Job {
 	return job
 }
 
+// clusterRole only allows the removal job to delete the objects of the removed
+// addons and to clean up after itself.
+func clusterRole(addons []rkeAddon) *rbac.ClusterRole {
+	role := &rbac.ClusterRole{
+		TypeMeta: meta.TypeMeta{
+			APIVersion: "rbac.authorization.k8s.io/v1",
+			Kind:       "ClusterRole",
+		},
+		ObjectMeta: meta.ObjectMeta{
+			Name: removalJobName,
+		},
+	}
+	for _, addon := range addons {
+		role.Rules = append(role.Rules, addon.rules...)
+	}
+	role.Rules = append(role.Rules,
+		rbac.PolicyRule{
+			APIGroups:     []string{"rbac.authorization.k8s.io"},
+			Resources:     []string{"clusterroles"},
+			ResourceNames: []string{removalJobName},
+			Verbs:         []string{"get", "delete"},
+		},
+		rbac.PolicyRule{
+			APIGroups:     []string{"rbac.authorization.k8s.io"},
+			Resources:     []string{"clusterrolebindings"},
+			ResourceNames: []string{removalJobName},
+			Verbs:         []string{"get", "patch"},
+		},
+		rbac.PolicyRule{
+			APIGroups:     []string{""},
+			Resources:     []string{"serviceaccounts"},
+			ResourceNames: []string{removalJobName},
+			Verbs:         []string{"get", "patch"},
+		},
+		rbac.PolicyRule{
+			APIGroups:     []string{"batch"},
+			Resources:     []string{"jobs"},
+			ResourceNames: []string{removalJobName},
+			Verbs:         []string{"get", "patch"},
+		},
+		// waiting for the helm-install jobs of the releases
+		rbac.PolicyRule{
+			APIGroups: []string{"batch"},
+			Resources: []string{"jobs"},
+			Verbs:     []string{"get", "list", "watch"},
+		},
+		// reading the manifests of the releases from the helm release secrets
+		rbac.PolicyRule{
+			APIGroups: []string{""},
+			Resources: []string{"secrets"},
+			Verbs:     []string{"get", "list"},
+		},
+	)
+	return role
+}
+
+func addonRule(apiGroup string, resources ...string) rbac.PolicyRule {
+	return rbac.PolicyRule{
+		APIGroups: []string{apiGroup},
+		Resources: resources,
+		Verbs:     []string{"get", "list", "watch", "patch", "delete"},
+	}
+}
+
+// manifestRules returns the rules allowing the removal job to hand over or
+// delete the objects of the addon manifest, one rule per API group.
+func manifestRules(manifest string) []rbac.PolicyRule {
+	resources := map[string]map[string]bool{}
+	var addKind func(obj map[string]interface{})
+	addKind = func(obj map[string]interface{}) {
+		apiVersion, _ := obj["apiVersion"].(string)
+		kind, _ := obj["kind"].(string)
+		if kind == "List" || strings.HasSuffix(kind, "List") {
+			items, _ := obj["items"].([]interface{})
+			for _, item := range items {
+				if itemObj, ok := item.(map[string]interface{}); ok {
+					addKind(itemObj)
+				}
+			}
+			return
+		}
+		gv, err := schema.ParseGroupVersion(apiVersion)
+		if err != nil || kind == "" {
+			return
+		}
+		resource, _ := apimeta.UnsafeGuessKindToResource(gv.WithKind(kind))
+		if resources[gv.Group] == nil {
+			resources[gv.Group] = map[string]bool{}
+		}
+		resources[gv.Group][resource.Resource] = true
+	}
+	for _, doc := range manifestSeparator.Split(manifest, -1) {
+		if strings.TrimSpace(doc) == "" {
+			continue
+		}
+		obj := map[string]interface{}{}
+		if err := yaml.Unmarshal([]byte(doc), &obj); err != nil {
+			logrus.Warnf("Failed to parse an object of the addon manifest, skipping it for the removal job permissions: %v", err)
+			continue
+		}
+		addKind(obj)
+	}
+
+	groups := make([]string, 0, len(resources))
+	for group := range resources {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+	rules := make([]rbac.PolicyRule, 0, len(groups))
+	for _, group := range groups {
+		names := make([]string, 0, len(resources[group]))
+		for resource := range resources[group] {
+			names = append(names, resource)
+		}
+		sort.Strings(names)
+		rules = append(rules, addonRule(group, names...))
+	}
+	return rules
+}
+
 func roleBinding() *rbac.ClusterRoleBinding {
 	return &rbac.ClusterRoleBinding{
 		TypeMeta: meta.TypeMeta{
@@ -228,16 +604,16 @@ func roleBinding() *rbac.ClusterRoleBinding {
 			Kind:       "ClusterRoleBinding",
 		},
 		ObjectMeta: meta.ObjectMeta{
-			Name: "migration-agent",
+			Name: removalJobName,
 		},
 		RoleRef: rbac.RoleRef{
 			Kind:     "ClusterRole",
 			APIGroup: "rbac.authorization.k8s.io",
-			Name:     "cluster-admin",
+			Name:     removalJobName,
 		},
 		Subjects: []rbac.Subject{
 			{
-				Name:      "migration-agent",
+				Name:      removalJobName,
 				Kind:      "ServiceAccount",
 				Namespace: "kube-system",
 			},
@@ -253,7 +629,7 @@ func serviceAccount() *core.ServiceAccount {
 			Kind:       "ServiceAccount",
 		},
 		ObjectMeta: meta.ObjectMeta{
-			Name:      "migration-agent",
+			Name:      removalJobName,
 			Namespace: "kube-system",
 		},
 		AutomountServiceAccountToken: &trueVal,
@@ -286,82 +662,144 @@ func toYaml(obj runtime.Object) (string, error) {
 // as a helm chart config to RKE2 and then save it to the manifest dir.
 func MigrateAddonsConfig(ctx context.Context, fullState *cluster.FullState, dataDir string) error {
 	coreDNSCfg := fullState.CurrentState.RancherKubernetesEngineConfig.DNS
-	rbac := (fullState.CurrentState.RancherKubernetesEngineConfig.Authorization.Mode == "rbac")
-	if err := doMigrateCoreDNSAddon(ctx, coreDNSCfg, dataDir, rbac); err != nil {
+	rbac := rbacEnabled(fullState.CurrentState.RancherKubernetesEngineConfig)
+	clusterDomain := fullState.CurrentState.RancherKubernetesEngineConfig.Services.Kubelet.ClusterDomain
+	images := fullState.CurrentState.RancherKubernetesEngineConfig.SystemImages
+	registry := chartsRegistry(fullState.CurrentState.RancherKubernetesEngineConfig)
+	if err := doMigrateCoreDNSAddon(ctx, coreDNSCfg, dataDir, rbac, clusterDomain, IsIPVSProxyMode(fullState), images, registry); err != nil {
 		return err
 	}
 	metricsServerCfg := fullState.CurrentState.RancherKubernetesEngineConfig.Monitoring
-	if err := doMigrateMetricsServer(ctx, &metricsServerCfg, dataDir, rbac); err != nil {
+	if err := doMigrateMetricsServer(ctx, &metricsServerCfg, dataDir, rbac, images, registry); err != nil {
 		return err
 	}
 	ingressCfg := fullState.CurrentState.RancherKubernetesEngineConfig.Ingress
-	return doMigrateNginxIngressAddon(ctx, ingressCfg, dataDir)
+	return doMigrateNginxIngressAddon(ctx, ingressCfg, fullState.CurrentState.RancherKubernetesEngineConfig.Version, dataDir, images, registry)
 
 }
 
-func doMigrateNginxIngressAddon(ctx context.Context, ingressCfg types.IngressConfig, dataDir string) error {
+func doMigrateNginxIngressAddon(ctx context.Context, ingressCfg types.IngressConfig, k8sVersion, dataDir string, images types.RKESystemImages, registry string) error {
 	if ingressCfg.Provider != "nginx" {
 		return nil
 	}
+	ingressValues := getIngressValues(ingressCfg, k8sVersion)
+	ingressValues.ControllerConfig.Image = chartImage(images.Ingress, registry)
+	ingressValues.DefaultBackend.Image = chartImage(images.IngressBackend, registry)
+	helmChartConfig, err := toHelmChartConfig("rke2-"+nginxIngress, ingressValues)
+	if err != nil {
+		return err
+	}
+
+	manifestsDir := manifestsDir(dataDir)
+	manifestFile := filepath.Join(manifestsDir, "rke2-"+nginxIngress+"-config.yaml")
+	err = os.MkdirAll(manifestsDir, 0700)
+	if err != nil {
+		return err
+	}
+
+	// deploy manifest file
+	return os.WriteFile(manifestFile, helmChartConfig, 0600)
+}
+
+// getIngressValues translates the RKE1 ingress config to the rke2-ingress-nginx
+// chart values, the network mode defaults to the one RKE used for the
+// kubernetes version of the cluster.
+func getIngressValues(ingressCfg types.IngressConfig, k8sVersion string) IngressConfig {
 	ingressValues := IngressConfig{
 		ControllerConfig: IngressControllerConfig{
 			Config:            ingressCfg.Options,
 			NodeSelector:      ingressCfg.NodeSelector,
-			ExtraArgs:         ingressCfg.ExtraArgs,
+			ExtraArgs:         map[string]string{},
 			ExtraEnvs:         ingressCfg.ExtraEnvs,
 			ExtraVolumes:      ingressCfg.ExtraVolumes,
 			ExtraVolumeMounts: ingressCfg.ExtraVolumeMounts,
 			Tolerations:       ingressCfg.Tolerations,
 			DNSPolicy:         ingressCfg.DNSPolicy,
-			HostPorts: IngressHostPorts{
-				Ports: IngressPorts{
-					HTTPPort:  ingressCfg.HTTPPort,
-					HTTPSPort: ingressCfg.HTTPSPort,
-				},
-			},
 			PriorityClassName: ingressCfg.NginxIngressControllerPriorityClassName,
+			// RKE1 nginx ingress always watched ingresses without a class
+			IngressClass:             defaultIngressClass,
+			WatchIngressWithoutClass: true,
+			IngressClassResource: IngressClassResource{
+				Name:    defaultIngressClass,
+				Enabled: true,
+				Default: ingressCfg.DefaultIngressClass == nil || *ingressCfg.DefaultIngressClass,
+			},
 		},
 		DefaultBackend: DefaultBackendConfig{
 			PriorityClassName: ingressCfg.DefaultHTTPBackendPriorityClassName,
-			Enabled:           *ingressCfg.DefaultBackend,
+			Enabled:           ingressCfg.DefaultBackend == nil || *ingressCfg.DefaultBackend,
 		},
 	}
-	ingressValues.ControllerConfig.UpdateStrategy = &appsv1.DaemonSetUpdateStrategy{
-		Type:          ingressCfg.UpdateStrategy.Strategy,
-		RollingUpdate: ingressCfg.UpdateStrategy.RollingUpdate,
-	}
-	helmChartConfig, err := toHelmChartConfig("rke2-"+nginxIngress, ingressValues)
-	if err != nil {
-		return err
+	for k, v := range ingressCfg.ExtraArgs {
+		ingressValues.ControllerConfig.ExtraArgs[k] = v
 	}
 
-	manifestsDir := manifestsDir(dataDir)
-	manifestFile := filepath.Join(manifestsDir, "rke2-"+nginxIngress+"-config.yaml")
-	err = os.MkdirAll(manifestsDir, 0700)
-	if err != nil {
-		return err
+	httpPort := ingressCfg.HTTPPort
+	if httpPort == 0 {
+		httpPort = 80
+	}
+	httpsPort := ingressCfg.HTTPSPort
+	if httpsPort == 0 {
+		httpsPort = 443
+	}
+	networkMode := ingressCfg.NetworkMode
+	if networkMode == "" {
+		networkMode = ingressNetworkModeHostNetwork
+		if v, err := version.ParseGeneric(k8sVersion); err == nil && v.AtLeast(version.MajorMinor(1, ingressHostPortDefaultMinor)) {
+			networkMode = ingressNetworkModeHostPort
+		}
+	}
+	switch networkMode {
+	case ingressNetworkModeHostPort:
+		ingressValues.ControllerConfig.HostPorts = IngressHostPorts{
+			Enabled: true,
+			Ports: IngressPorts{
+				HTTPPort:  httpPort,
+				HTTPSPort: httpsPort,
+			},
+		}
+	case noneProvider:
+		// neither host ports nor host network, the controller is exposed by the user
+	default:
+		ingressValues.ControllerConfig.HostNetwork = true
+		if ingressValues.ControllerConfig.DNSPolicy == "" {
+			ingressValues.ControllerConfig.DNSPolicy = "ClusterFirstWithHostNet"
+		}
+		// with host network the ports are set on the controller itself
+		if httpPort != 80 {
+			ingressValues.ControllerConfig.ExtraArgs["http-port"] = strconv.Itoa(httpPort)
+		}
+		if httpsPort != 443 {
+			ingressValues.ControllerConfig.ExtraArgs["https-port"] = strconv.Itoa(httpsPort)
+		}
 	}
 
-	// deploy manifest file
-	return os.WriteFile(manifestFile, helmChartConfig, 0600)
+	if ingressCfg.UpdateStrategy != nil {
+		ingressValues.ControllerConfig.UpdateStrategy = &appsv1.DaemonSetUpdateStrategy{
+			Type:          ingressCfg.UpdateStrategy.Strategy,
+			RollingUpdate: ingressCfg.UpdateStrategy.RollingUpdate,
+		}
+	}
+	return ingressValues
 }
 
-func doMigrateCoreDNSAddon(ctx context.Context, corednsCfg *types.DNSConfig, dataDir string, rbac bool) error {
-	if corednsCfg.Provider != "coredns" {
+func doMigrateCoreDNSAddon(ctx context.Context, corednsCfg *types.DNSConfig, dataDir string, rbac bool, clusterDomain string, ipvs bool, images types.RKESystemImages, registry string) error {
+	if corednsCfg == nil || corednsCfg.Provider != "coredns" {
 		return nil
 	}
 	dnsValues := CoreDNSConfig{
 		PriorityClassName: corednsCfg.Options[cluster.CoreDNSPriorityClassNameKey],
 		NodeSelector:      corednsCfg.NodeSelector,
-		RollingUpdate:     corednsCfg.UpdateStrategy.RollingUpdate,
 		Tolerations:       corednsCfg.Tolerations,
 		AutoScalerConfig: AutoScalerConfig{
 			Enabled:           true,
 			PriorityClassName: corednsCfg.Options[cluster.CoreDNSAutoscalerPriorityClassNameKey],
+			Image:             chartImage(images.CoreDNSAutoscaler, registry),
 		},
 		RBAC: RBACConfig{
 			Create: rbac,
 		},
+		Image: chartImage(images.CoreDNS, registry),
 	}
 	if corednsCfg.LinearAutoscalerParams != nil {
 		dnsValues.AutoScalerConfig.CoresPerReplica = corednsCfg.LinearAutoscalerParams.CoresPerReplica
@@ -377,6 +815,31 @@ func doMigrateCoreDNSAddon(ctx context.Context, corednsCfg *types.DNSConfig, dat
 		dnsValues.AutoScalerConfig.PreventSinglePointFailure = true
 
 	}
+	if corednsCfg.UpdateStrategy != nil {
+		if corednsCfg.UpdateStrategy.Strategy == appsv1.RecreateDeploymentStrategyType {
+			logrus.Warnf("CoreDNS Recreate update strategy is not supported by rke2-%s, rolling updates will be used", coredns)
+		}
+		dnsValues.RollingUpdate = corednsCfg.UpdateStrategy.RollingUpdate
+	}
+	if len(corednsCfg.UpstreamNameservers) > 0 || len(corednsCfg.ReverseCIDRs) > 0 || len(corednsCfg.StubDomains) > 0 {
+		dnsValues.Servers = coreDNSServers(corednsCfg, clusterDomain)
+	}
+	if corednsCfg.Nodelocal != nil && corednsCfg.Nodelocal.IPAddress != "" {
+		logrus.Infof("NodeLocal DNS is enabled in RKE state, migrating config to rke2-%s", coredns)
+		dnsValues.NodeLocal = &NodeLocalConfig{
+			Enabled:           true,
+			IPAddress:         corednsCfg.Nodelocal.IPAddress,
+			IPVS:              ipvs,
+			NodeSelector:      corednsCfg.Nodelocal.NodeSelector,
+			PriorityClassName: corednsCfg.Nodelocal.NodeLocalDNSPriorityClassName,
+		}
+		if corednsCfg.Nodelocal.UpdateStrategy != nil {
+			dnsValues.NodeLocal.UpdateStrategy = &appsv1.DaemonSetUpdateStrategy{
+				Type:          corednsCfg.Nodelocal.UpdateStrategy.Strategy,
+				RollingUpdate: corednsCfg.Nodelocal.UpdateStrategy.RollingUpdate,
+			}
+		}
+	}
 	helmChartConfig, err := toHelmChartConfig("rke2-"+coredns, dnsValues)
 	if err != nil {
 		return err
@@ -393,7 +856,64 @@ func doMigrateCoreDNSAddon(ctx context.Context, corednsCfg *types.DNSConfig, dat
 	return os.WriteFile(manifestFile, helmChartConfig, 0600)
 }
 
-func doMigrateMetricsServer(ctx context.Context, metricsCfg *types.MonitoringConfig, dataDir string, rbac bool) error {
+// coreDNSServers builds the rke2-coredns server blocks matching the Corefile
+// RKE1 generates for upstream nameservers, reverse CIDRs and stub domains.
+func coreDNSServers(corednsCfg *types.DNSConfig, clusterDomain string) []CoreDNSServer {
+	if clusterDomain == "" {
+		clusterDomain = "cluster.local"
+	}
+	reverseZones := "in-addr.arpa ip6.arpa"
+	if len(corednsCfg.ReverseCIDRs) > 0 {
+		reverseZones = strings.Join(corednsCfg.ReverseCIDRs, " ")
+	}
+	upstream := "/etc/resolv.conf"
+	if len(corednsCfg.UpstreamNameservers) > 0 {
+		upstream = strings.Join(corednsCfg.UpstreamNameservers, " ")
+	}
+
+	servers := []CoreDNSServer{
+		{
+			Zones: []CoreDNSZone{{Zone: "."}},
+			Port:  53,
+			Plugins: []CoreDNSPlugin{
+				{Name: "errors"},
+				{Name: "health", ConfigBlock: "lameduck 5s"},
+				{Name: "ready"},
+				{
+					Name:        "kubernetes",
+					Parameters:  clusterDomain + " " + reverseZones,
+					ConfigBlock: "pods insecure\nfallthrough in-addr.arpa ip6.arpa",
+				},
+				{Name: "prometheus", Parameters: "0.0.0.0:9153"},
+				{Name: "forward", Parameters: ". " + upstream},
+				{Name: "cache", Parameters: "30"},
+				{Name: "loop"},
+				{Name: "reload"},
+				{Name: "loadbalance"},
+			},
+		},
+	}
+
+	stubDomains := make([]string, 0, len(corednsCfg.StubDomains))
+	for domain := range corednsCfg.StubDomains {
+		stubDomains = append(stubDomains, domain)
+	}
+	sort.Strings(stubDomains)
+	for _, domain := range stubDomains {
+		servers = append(servers, CoreDNSServer{
+			Zones: []CoreDNSZone{{Zone: domain}},
+			Port:  53,
+			Plugins: []CoreDNSPlugin{
+				{Name: "errors"},
+				{Name: "cache", Parameters: "30"},
+				{Name: "forward", Parameters: ". " + strings.Join(corednsCfg.StubDomains[domain], " ")},
+			},
+		})
+	}
+	return servers
+}
+
+func doMigrateMetricsServer(ctx context.Context, metricsCfg *types.MonitoringConfig, dataDir string, rbac bool, images types.RKESystemImages, registry string) error {
 	if metricsCfg.Provider != "metrics-server" {
 		return nil
 	}
@@ -406,6 +926,7 @@ func doMigrateMetricsServer(ctx context.Context, metricsCfg *types.MonitoringCon
 		RBAC: RBACConfig{
 			Create: rbac,
 		},
+		Image: chartImage(images.MetricsServer, registry),
 	}
 	helmChartConfig, err := toHelmChartConfig("rke2-"+metricsServer, metricsValues)
 	if err != nil {
@@ -433,17 +954,20 @@ func mapToSlice(args map[string]string) []string {
 
 // MigrateUserAddonsConfig should read the user addons configuration and copy it
 // to RKE2 and then save it to the manifest dir.
-func MigrateUserAddonsConfig(ctx context.Context, fullState *cluster.FullState, dataDir string, configMap v1.ConfigMapController) error {
+func MigrateUserAddonsConfig(ctx context.Context, fullState *cluster.FullState, dataDir, clusterDir, includeCA, k8sVersion string, configMap v1.ConfigMapController) error {
 	userAddons := fullState.CurrentState.RancherKubernetesEngineConfig.Addons
 	userAddonsInclude := fullState.CurrentState.RancherKubernetesEngineConfig.AddonsInclude
-	if err := doMigrateUserAddons(ctx, userAddons, dataDir); err != nil {
+	if k8sVersion == "" {
+		k8sVersion = fullState.CurrentState.RancherKubernetesEngineConfig.Version
+	}
+	if err := doMigrateUserAddons(ctx, userAddons, dataDir, k8sVersion); err != nil {
 		return err
 	}
-	return doMigrateUserAddonsInclude(ctx, userAddonsInclude, dataDir, configMap)
+	return doMigrateUserAddonsInclude(ctx, userAddonsInclude, dataDir, clusterDir, includeCA, k8sVersion, configMap)
 }
 
 // doMigrateUserAddons will just deploy the useraddons paremeter of cluster.rkestate to the manifest dir
-func doMigrateUserAddons(ctx context.Context, userAddons string, dataDir string) error {
+func doMigrateUserAddons(ctx context.Context, userAddons string, dataDir, k8sVersion string) error {
 	if userAddons == "" {
 		return nil
 	}
@@ -453,26 +977,212 @@ func doMigrateUserAddons(ctx context.Context, userAddons string, dataDir string)
 		return err
 	}
 	// deploy manifest file
-	return os.WriteFile(manifestFile, []byte(userAddons), 0600)
+	return os.WriteFile(manifestFile, convertRemovedAPIs([]byte(userAddons), "addons", k8sVersion), 0600)
 }
 
-// doMigrateUserAddonsInclude will just deploy the useraddons paremeter of cluster.rkestate to the manifest dir
-func doMigrateUserAddonsInclude(ctx context.Context, userAddonsInclude []string, dataDir string, configMap v1.ConfigMapController) error {
-	if configMap == nil {
-		logrus.Warnf("no configmap controller defined")
+// doMigrateUserAddonsInclude fetches every addons_include entry from its local
+// path or url and deploys it as its own manifest. When entries can not be
+// fetched, the objects of the rke-user-includes-addons ConfigMap that are not
+// in the fetched entries are deployed instead.
+func doMigrateUserAddonsInclude(ctx context.Context, userAddonsInclude []string, dataDir, clusterDir, includeCA, k8sVersion string, configMap v1.ConfigMapController) error {
+	if len(userAddonsInclude) == 0 {
 		return nil
 	}
-	if len(userAddonsInclude) == 0 {
+	manifestsDir := manifestsDir(dataDir)
+	if err := os.MkdirAll(manifestsDir, 0700); err != nil {
+		return err
+	}
+
+	var unresolved []string
+	fetched := map[string]bool{}
+	for _, include := range userAddonsInclude {
+		content, err := fetchAddonInclude(ctx, include, clusterDir, includeCA)
+		if err != nil {
+			logrus.Warnf("Failed to fetch user addon %s: %v", include, err)
+			unresolved = append(unresolved, include)
+			continue
+		}
+		for key := range manifestObjectKeys(content) {
+			fetched[key] = true
+		}
+		manifestFile := filepath.Join(manifestsDir, addonIncludeManifestName(include))
+		logrus.Infof("Migrating user addon %s to %s", include, manifestFile)
+		if err := os.WriteFile(manifestFile, convertRemovedAPIs(content, include, k8sVersion), 0600); err != nil {
+			return err
+		}
+	}
+	if len(unresolved) == 0 {
 		return nil
 	}
+
+	// RKE1 keeps the combined content of the includes in a ConfigMap
+	if configMap == nil {
+		return fmt.Errorf("failed to fetch user addons %s and no kubernetes connection to read them from the %s ConfigMap",
+			strings.Join(unresolved, ", "), userAddonsIncludeConfigMap)
+	}
 	addonsConfigMap, err := configMap.Get("kube-system", userAddonsIncludeConfigMap, meta.GetOptions{})
 	if err != nil {
 		return err
 	}
-
-	manifestsDir := manifestsDir(dataDir)
+	// the objects of the fetched entries are already deployed
+	content, err := removeManifestObjects([]byte(addonsConfigMap.Data[userAddonsIncludeConfigMap]), fetched)
+	if err != nil {
+		return fmt.Errorf("failed to read the user addons %s from the %s ConfigMap: %v", strings.Join(unresolved, ", "), userAddonsIncludeConfigMap, err)
+	}
+	if len(content) == 0 {
+		return fmt.Errorf("user addons %s not found in the %s ConfigMap", strings.Join(unresolved, ", "), userAddonsIncludeConfigMap)
+	}
 	manifestFile := filepath.Join(manifestsDir, userAddonsIncludeConfigMap+".yaml")
+	logrus.Infof("Migrating user addons %s from the %s ConfigMap to %s", strings.Join(unresolved, ", "), userAddonsIncludeConfigMap, manifestFile)
 
 	// deploy manifest file
-	return os.WriteFile(manifestFile, []byte(addonsConfigMap.Data[userAddonsIncludeConfigMap]), 0600)
+	return os.WriteFile(manifestFile, convertRemovedAPIs(content, userAddonsIncludeConfigMap, k8sVersion), 0600)
+}
+
+// manifestObjectKeys returns the kind, namespace and name of the objects of the
+// manifest, including the items of Lists.
+func manifestObjectKeys(manifest []byte) map[string]bool {
+	keys := map[string]bool{}
+	for _, doc := range manifestSeparator.Split(string(manifest), -1) {
+		obj := map[string]interface{}{}
+		if err := yaml.Unmarshal([]byte(doc), &obj); err != nil {
+			continue
+		}
+		for _, item := range manifestItems(obj) {
+			keys[objectKey(item)] = true
+		}
+	}
+	return keys
+}
+
+// removeManifestObjects drops the objects with the given keys from the
+// manifest, Lists are rewritten with their remaining items.
+func removeManifestObjects(manifest []byte, keys map[string]bool) ([]byte, error) {
+	var docs []string
+	for _, doc := range manifestSeparator.Split(string(manifest), -1) {
+		if strings.TrimSpace(doc) == "" {
+			continue
+		}
+		obj := map[string]interface{}{}
+		if err := yaml.Unmarshal([]byte(doc), &obj); err != nil {
+			return nil, err
+		}
+		if len(obj) == 0 {
+			continue
+		}
+		items, isList := obj["items"].([]interface{})
+		if !isList {
+			if !keys[objectKey(obj)] {
+				docs = append(docs, doc)
+			}
+			continue
+		}
+		var remaining []interface{}
+		for _, item := range items {
+			if itemObj, ok := item.(map[string]interface{}); ok && keys[objectKey(itemObj)] {
+				continue
+			}
+			remaining = append(remaining, item)
+		}
+		if len(remaining) == len(items) {
+			docs = append(docs, doc)
+			continue
+		}
+		if len(remaining) == 0 {
+			continue
+		}
+		obj["items"] = remaining
+		out, err := yaml.Marshal(obj)
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, "\n"+string(out))
+	}
+	if len(docs) == 0 {
+		return nil, nil
+	}
+	return []byte(strings.Join(docs, "---")), nil
+}
+
+// manifestItems returns the items of a List, or the object itself.
+func manifestItems(obj map[string]interface{}) []map[string]interface{} {
+	items, ok := obj["items"].([]interface{})
+	if !ok {
+		if len(obj) == 0 {
+			return nil
+		}
+		return []map[string]interface{}{obj}
+	}
+	var objs []map[string]interface{}
+	for _, item := range items {
+		if itemObj, ok := item.(map[string]interface{}); ok {
+			objs = append(objs, itemObj)
+		}
+	}
+	return objs
+}
+
+func objectKey(obj map[string]interface{}) string {
+	kind, _ := obj["kind"].(string)
+	return kind + "/" + objectName(obj)
+}
+
+// fetchAddonInclude reads an addons_include entry, urls are downloaded and local
+// paths are relative to the cluster dir like they are relative to cluster.yml.
+func fetchAddonInclude(ctx context.Context, include, clusterDir, includeCA string) ([]byte, error) {
+	if !strings.HasPrefix(include, "http://") && !strings.HasPrefix(include, "https://") {
+		file := include
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(clusterDir, file)
+		}
+		return os.ReadFile(file)
+	}
+
+	tlsConfig := &tls.Config{}
+	if includeCA != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		ca, err := os.ReadFile(includeCA)
+		if err != nil {
+			return nil, err
+		}
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificates found in %s", includeCA)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	client := &http.Client{
+		Timeout: 30 * time.Second,
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: tlsConfig,
+		},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, include, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// addonIncludeManifestName derives a stable manifest name from the source of
+// the addon, the hash keeps entries with the same base name apart.
+func addonIncludeManifestName(include string) string {
+	hash := sha256.Sum256([]byte(include))
+	base := strings.TrimSuffix(path.Base(include), path.Ext(include))
+	base = strings.Trim(invalidManifestNameChars.ReplaceAllString(strings.ToLower(base), "-"), "-.")
+	if base == "" {
+		base = "addon"
+	}
+	return fmt.Sprintf("%s-%s-%s.yaml", userAddonsIncludeConfigMap, base, hex.EncodeToString(hash[:4]))
 }
@@ -0,0 +1,224 @@
+package config
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/rancher/rke/types"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	rbac "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/yaml"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+func TestIngressValues(t *testing.T) {
+	disabled := false
+	maxUnavailable := intstr.FromInt(2)
+	tests := []struct {
+		name       string
+		k8sVersion string
+		ingressCfg types.IngressConfig
+	}{
+		{
+			name:       "v1.20-defaults",
+			k8sVersion: "v1.20.15-rancher1-1",
+			ingressCfg: types.IngressConfig{
+				Provider: "nginx",
+			},
+		},
+		{
+			name:       "v1.21-defaults",
+			k8sVersion: "v1.21.14-rancher1-1",
+			ingressCfg: types.IngressConfig{
+				Provider: "nginx",
+			},
+		},
+		{
+			name:       "v1.24-host-network-ports",
+			k8sVersion: "v1.24.17-rancher1-1",
+			ingressCfg: types.IngressConfig{
+				Provider:    "nginx",
+				NetworkMode: "hostNetwork",
+				HTTPPort:    8080,
+				HTTPSPort:   8443,
+				DNSPolicy:   "ClusterFirst",
+			},
+		},
+		{
+			name:       "v1.21-host-port-full",
+			k8sVersion: "v1.21.14-rancher1-1",
+			ingressCfg: types.IngressConfig{
+				Provider:     "nginx",
+				NetworkMode:  "hostPort",
+				HTTPPort:     8080,
+				HTTPSPort:    8443,
+				Options:      map[string]string{"use-forwarded-headers": "true"},
+				NodeSelector: map[string]string{"app": "ingress"},
+				ExtraArgs:    map[string]string{"enable-ssl-passthrough": ""},
+				ExtraEnvs: []types.ExtraEnv{
+					{EnvVar: v1.EnvVar{Name: "TZ", Value: "UTC"}},
+				},
+				Tolerations: []v1.Toleration{
+					{Key: "ingress", Operator: v1.TolerationOpExists, Effect: v1.TaintEffectNoSchedule},
+				},
+				UpdateStrategy: &types.DaemonSetUpdateStrategy{
+					Strategy: appsv1.RollingUpdateDaemonSetStrategyType,
+					RollingUpdate: &appsv1.RollingUpdateDaemonSet{
+						MaxUnavailable: &maxUnavailable,
+					},
+				},
+				NginxIngressControllerPriorityClassName: "ingress-priority",
+				DefaultHTTPBackendPriorityClassName:     "backend-priority",
+				DefaultBackend:                          &disabled,
+				DefaultIngressClass:                     &disabled,
+			},
+		},
+		{
+			name:       "v1.22-none",
+			k8sVersion: "v1.22.17-rancher1-1",
+			ingressCfg: types.IngressConfig{
+				Provider:    "nginx",
+				NetworkMode: "none",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := yaml.Marshal(getIngressValues(tt.ingressCfg, tt.k8sVersion))
+			if err != nil {
+				t.Fatal(err)
+			}
+			golden := filepath.Join("testdata", "ingress", tt.name+".yaml")
+			if *update {
+				if err := os.WriteFile(golden, got, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != string(want) {
+				t.Errorf("values differ from %s, got:\n%s", golden, got)
+			}
+		})
+	}
+}
+
+func TestManifestRules(t *testing.T) {
+	manifest := `---
+apiVersion: v1
+kind: ServiceAccount
+metadata:
+  name: canal
+---
+apiVersion: policy/v1beta1
+kind: PodSecurityPolicy
+metadata:
+  name: canal
+---
+apiVersion: apps/v1
+kind: DaemonSet
+metadata:
+  name: canal
+---
+apiVersion: v1
+kind: List
+items:
+- apiVersion: networking.k8s.io/v1
+  kind: NetworkPolicy
+  metadata:
+    name: default-deny
+- apiVersion: v1
+  kind: ConfigMap
+  metadata:
+    name: canal-config
+---
+# an empty document
+---
+apiVersion: crd.projectcalico.org/v1
+kind: IPPool
+metadata:
+  name: default-ipv4-ippool
+`
+	want := []rbac.PolicyRule{
+		addonRule("", "configmaps", "serviceaccounts"),
+		addonRule("apps", "daemonsets"),
+		addonRule("crd.projectcalico.org", "ippools"),
+		addonRule("networking.k8s.io", "networkpolicies"),
+		addonRule("policy", "podsecuritypolicies"),
+	}
+	if got := manifestRules(manifest); !reflect.DeepEqual(got, want) {
+		t.Errorf("manifestRules() = %v, want %v", got, want)
+	}
+}
+
+func TestRemoveManifestObjects(t *testing.T) {
+	fetched := manifestObjectKeys([]byte(`apiVersion: v1
+kind: Namespace
+metadata:
+  name: monitoring
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: exporter
+  namespace: monitoring
+`))
+	combined := `---
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: monitoring
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: exporter
+  namespace: monitoring
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: exporter
+  namespace: default
+---
+apiVersion: v1
+kind: List
+items:
+- apiVersion: v1
+  kind: Namespace
+  metadata:
+    name: monitoring
+- apiVersion: v1
+  kind: Secret
+  metadata:
+    name: unfetched
+`
+	content, err := removeManifestObjects([]byte(combined), fetched)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]bool{
+		"ConfigMap/default/exporter": true,
+		"Secret/unfetched":           true,
+	}
+	if got := manifestObjectKeys(content); !reflect.DeepEqual(got, want) {
+		t.Errorf("remaining objects = %v, want %v\n%s", got, want, content)
+	}
+
+	// nothing is left once every object was fetched
+	content, err = removeManifestObjects([]byte(combined), manifestObjectKeys([]byte(combined)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(content) != 0 {
+		t.Errorf("expected no remaining objects, got\n%s", content)
+	}
+}
@@ -0,0 +1,232 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/util/version"
+	"sigs.k8s.io/yaml"
+)
+
+// removedAPI is a kubernetes API version removed in a minor release, objects
+// using it are converted to the replacement version when convert is set and
+// reported otherwise.
+type removedAPI struct {
+	apiVersion  string
+	kinds       []string
+	removedIn   uint
+	replacement string
+	convert     func(obj map[string]interface{})
+}
+
+var (
+	manifestSeparator = regexp.MustCompile(`(?m)^---[ \t]*$`)
+
+	workloadKinds = []string{"Deployment", "DaemonSet", "ReplicaSet", "StatefulSet"}
+
+	removedAPIs = []removedAPI{
+		{apiVersion: "extensions/v1beta1", kinds: workloadKinds, removedIn: 16, replacement: "apps/v1", convert: convertWorkload},
+		{apiVersion: "apps/v1beta1", kinds: workloadKinds, removedIn: 16, replacement: "apps/v1", convert: convertWorkload},
+		{apiVersion: "apps/v1beta2", kinds: workloadKinds, removedIn: 16, replacement: "apps/v1", convert: convertWorkload},
+		{apiVersion: "extensions/v1beta1", kinds: []string{"NetworkPolicy"}, removedIn: 16, replacement: "networking.k8s.io/v1", convert: noConversion},
+		{apiVersion: "extensions/v1beta1", kinds: []string{"PodSecurityPolicy"}, removedIn: 16, replacement: "policy/v1beta1", convert: noConversion},
+		{apiVersion: "extensions/v1beta1", kinds: []string{"Ingress"}, removedIn: 22, replacement: "networking.k8s.io/v1", convert: convertIngress},
+		{apiVersion: "networking.k8s.io/v1beta1", kinds: []string{"Ingress"}, removedIn: 22, replacement: "networking.k8s.io/v1", convert: convertIngress},
+		{apiVersion: "networking.k8s.io/v1beta1", kinds: []string{"IngressClass"}, removedIn: 22, replacement: "networking.k8s.io/v1", convert: noConversion},
+		{apiVersion: "rbac.authorization.k8s.io/v1beta1", removedIn: 22, replacement: "rbac.authorization.k8s.io/v1", convert: noConversion},
+		{apiVersion: "apiregistration.k8s.io/v1beta1", removedIn: 22, replacement: "apiregistration.k8s.io/v1", convert: noConversion},
+		{apiVersion: "scheduling.k8s.io/v1beta1", removedIn: 22, replacement: "scheduling.k8s.io/v1", convert: noConversion},
+		{apiVersion: "coordination.k8s.io/v1beta1", removedIn: 22, replacement: "coordination.k8s.io/v1", convert: noConversion},
+		{apiVersion: "storage.k8s.io/v1beta1", kinds: []string{"StorageClass", "CSIDriver", "CSINode", "VolumeAttachment"}, removedIn: 22, replacement: "storage.k8s.io/v1", convert: noConversion},
+		{apiVersion: "apiextensions.k8s.io/v1beta1", removedIn: 22, replacement: "apiextensions.k8s.io/v1"},
+		{apiVersion: "admissionregistration.k8s.io/v1beta1", removedIn: 22, replacement: "admissionregistration.k8s.io/v1"},
+		{apiVersion: "certificates.k8s.io/v1beta1", removedIn: 22, replacement: "certificates.k8s.io/v1"},
+		{apiVersion: "batch/v1beta1", kinds: []string{"CronJob"}, removedIn: 25, replacement: "batch/v1", convert: noConversion},
+		{apiVersion: "policy/v1beta1", kinds: []string{"PodDisruptionBudget"}, removedIn: 25, replacement: "policy/v1", convert: noConversion},
+		{apiVersion: "policy/v1beta1", kinds: []string{"PodSecurityPolicy"}, removedIn: 25},
+		{apiVersion: "discovery.k8s.io/v1beta1", removedIn: 25, replacement: "discovery.k8s.io/v1"},
+		{apiVersion: "events.k8s.io/v1beta1", removedIn: 25, replacement: "events.k8s.io/v1"},
+		{apiVersion: "autoscaling/v2beta1", removedIn: 25, replacement: "autoscaling/v2"},
+		{apiVersion: "autoscaling/v2beta2", removedIn: 26, replacement: "autoscaling/v2", convert: noConversion},
+		{apiVersion: "flowcontrol.apiserver.k8s.io/v1beta1", removedIn: 26, replacement: "flowcontrol.apiserver.k8s.io/v1beta2", convert: noConversion},
+	}
+)
+
+// RemovedAPI returns the kubernetes minor version removing the API version of
+// the kind and its replacement, false is returned if the API version is served.
+func RemovedAPI(apiVersion, kind string) (uint, string, bool) {
+	for _, api := range removedAPIs {
+		if api.apiVersion == apiVersion && (len(api.kinds) == 0 || containsString(api.kinds, kind)) {
+			return api.removedIn, api.replacement, true
+		}
+	}
+	return 0, "", false
+}
+
+// convertRemovedAPIs rewrites the objects of the manifest using API versions
+// removed in the target kubernetes version to their replacement version, the
+// objects that can not be converted are reported for manual migration.
+func convertRemovedAPIs(manifest []byte, source, k8sVersion string) []byte {
+	target, err := version.ParseGeneric(k8sVersion)
+	if err != nil {
+		logrus.Warnf("Unknown target kubernetes version %s, skipping removed API detection for %s", k8sVersion, source)
+		return manifest
+	}
+
+	docs := manifestSeparator.Split(string(manifest), -1)
+	changed := false
+	for i, doc := range docs {
+		if strings.TrimSpace(doc) == "" {
+			continue
+		}
+		obj := map[string]interface{}{}
+		if err := yaml.Unmarshal([]byte(doc), &obj); err != nil {
+			logrus.Warnf("Failed to parse an object of %s, skipping removed API detection for it: %v", source, err)
+			continue
+		}
+		if !convertObject(obj, target, source) {
+			continue
+		}
+		out, err := yaml.Marshal(obj)
+		if err != nil {
+			logrus.Warnf("Failed to write the converted object %s of %s: %v", objectName(obj), source, err)
+			continue
+		}
+		docs[i] = "\n" + string(out)
+		changed = true
+	}
+	if !changed {
+		return manifest
+	}
+	return []byte(strings.Join(docs, "---"))
+}
+
+// convertObject converts the object and the items of a List in place, it
+// returns true if anything was converted.
+func convertObject(obj map[string]interface{}, target *version.Version, source string) bool {
+	apiVersion, _ := obj["apiVersion"].(string)
+	kind, _ := obj["kind"].(string)
+	if kind == "List" {
+		converted := false
+		items, _ := obj["items"].([]interface{})
+		for _, item := range items {
+			if itemObj, ok := item.(map[string]interface{}); ok && convertObject(itemObj, target, source) {
+				converted = true
+			}
+		}
+		return converted
+	}
+
+	for _, api := range removedAPIs {
+		if api.apiVersion != apiVersion || (len(api.kinds) > 0 && !containsString(api.kinds, kind)) {
+			continue
+		}
+		if target.Major() != 1 || target.Minor() < api.removedIn {
+			return false
+		}
+		if api.convert == nil {
+			if api.replacement == "" {
+				logrus.Warnf("Manual step required: %s %s in %s uses %s which is removed in kubernetes v1.%d without replacement",
+					kind, objectName(obj), source, apiVersion, api.removedIn)
+			} else {
+				logrus.Warnf("Manual step required: %s %s in %s uses %s which is removed in kubernetes v1.%d and can not be converted to %s automatically",
+					kind, objectName(obj), source, apiVersion, api.removedIn, api.replacement)
+			}
+			return false
+		}
+		logrus.Infof("Converting %s %s in %s from %s to %s", kind, objectName(obj), source, apiVersion, api.replacement)
+		obj["apiVersion"] = api.replacement
+		api.convert(obj)
+		// the replacement can be removed in the target version as well
+		convertObject(obj, target, source)
+		return true
+	}
+	return false
+}
+
+func noConversion(obj map[string]interface{}) {}
+
+// convertWorkload converts beta workloads to apps/v1, which requires a selector
+// the beta versions defaulted to the pod template labels.
+func convertWorkload(obj map[string]interface{}) {
+	spec, ok := obj["spec"].(map[string]interface{})
+	if !ok {
+		return
+	}
+	delete(spec, "rollbackTo")
+	delete(spec, "templateGeneration")
+	if _, ok := spec["selector"]; ok {
+		return
+	}
+	template, _ := spec["template"].(map[string]interface{})
+	metadata, _ := template["metadata"].(map[string]interface{})
+	if labels, ok := metadata["labels"].(map[string]interface{}); ok {
+		spec["selector"] = map[string]interface{}{
+			"matchLabels": labels,
+		}
+	}
+}
+
+// convertIngress converts beta ingresses to networking.k8s.io/v1, the backends
+// are restructured and paths without a type get the implementation specific
+// type matching the beta behaviour.
+func convertIngress(obj map[string]interface{}) {
+	spec, ok := obj["spec"].(map[string]interface{})
+	if !ok {
+		return
+	}
+	if backend, ok := spec["backend"].(map[string]interface{}); ok {
+		spec["defaultBackend"] = convertIngressBackend(backend)
+		delete(spec, "backend")
+	}
+	rules, _ := spec["rules"].([]interface{})
+	for _, rule := range rules {
+		ruleObj, _ := rule.(map[string]interface{})
+		http, _ := ruleObj["http"].(map[string]interface{})
+		paths, _ := http["paths"].([]interface{})
+		for _, p := range paths {
+			pathObj, ok := p.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if _, ok := pathObj["pathType"]; !ok {
+				pathObj["pathType"] = "ImplementationSpecific"
+			}
+			if backend, ok := pathObj["backend"].(map[string]interface{}); ok {
+				pathObj["backend"] = convertIngressBackend(backend)
+			}
+		}
+	}
+}
+
+func convertIngressBackend(backend map[string]interface{}) map[string]interface{} {
+	serviceName, ok := backend["serviceName"]
+	if !ok {
+		// resource backends are unchanged
+		return backend
+	}
+	port := map[string]interface{}{}
+	switch servicePort := backend["servicePort"].(type) {
+	case string:
+		port["name"] = servicePort
+	case float64:
+		port["number"] = int64(servicePort)
+	}
+	return map[string]interface{}{
+		"service": map[string]interface{}{
+			"name": serviceName,
+			"port": port,
+		},
+	}
+}
+
+func objectName(obj map[string]interface{}) string {
+	metadata, _ := obj["metadata"].(map[string]interface{})
+	name, _ := metadata["name"].(string)
+	if namespace, ok := metadata["namespace"].(string); ok && namespace != "" {
+		return fmt.Sprintf("%s/%s", namespace, name)
+	}
+	return name
+}
@@ -0,0 +1,265 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/util/version"
+)
+
+const (
+	kubeAPIServerComponent         = "kube-apiserver"
+	kubeControllerManagerComponent = "kube-controller-manager"
+	kubeSchedulerComponent         = "kube-scheduler"
+	kubeletComponent               = "kubelet"
+	kubeProxyComponent             = "kube-proxy"
+	etcdComponent                  = "etcd"
+
+	rkeSSLDir = "/etc/kubernetes/ssl"
+)
+
+// argsTranslation describes how the extra args of an RKE1 component
+// should be handled when migrated to RKE2.
+type argsTranslation struct {
+	// managed flags are set by RKE2 itself and must not be overridden
+	managed []string
+	// removed maps flags to the kubernetes minor version they were removed in
+	removed map[string]uint
+}
+
+var componentArgs = map[string]argsTranslation{
+	kubeAPIServerComponent: {
+		managed: []string{
+			"advertise-address",
+			"allow-privileged",
+			"bind-address",
+			"client-ca-file",
+			"cloud-config",
+			"cloud-provider",
+			"etcd-cafile",
+			"etcd-certfile",
+			"etcd-keyfile",
+			"etcd-prefix",
+			"etcd-servers",
+			"kubelet-certificate-authority",
+			"kubelet-client-certificate",
+			"kubelet-client-key",
+			"proxy-client-cert-file",
+			"proxy-client-key-file",
+			"requestheader-client-ca-file",
+			"secure-port",
+			"service-account-issuer",
+			"service-account-key-file",
+			"service-account-signing-key-file",
+			"service-cluster-ip-range",
+			"service-node-port-range",
+			"storage-backend",
+			"tls-cert-file",
+			"tls-private-key-file",
+		},
+		removed: map[string]uint{
+			"enable-swagger-ui":                       14,
+			"repair-malformed-updates":                15,
+			"basic-auth-file":                         19,
+			"kubelet-https":                           22,
+			"insecure-bind-address":                   24,
+			"insecure-port":                           24,
+			"experimental-encryption-provider-config": 14,
+		},
+	},
+	kubeControllerManagerComponent: {
+		managed: []string{
+			"authentication-kubeconfig",
+			"authorization-kubeconfig",
+			"bind-address",
+			"cloud-config",
+			"cloud-provider",
+			"cluster-cidr",
+			"cluster-signing-cert-file",
+			"cluster-signing-key-file",
+			"kubeconfig",
+			"root-ca-file",
+			"secure-port",
+			"service-account-private-key-file",
+			"service-cluster-ip-range",
+		},
+		removed: map[string]uint{
+			"horizontal-pod-autoscaler-use-rest-clients": 21,
+			"address": 24,
+			"port":    24,
+		},
+	},
+	kubeSchedulerComponent: {
+		managed: []string{
+			"authentication-kubeconfig",
+			"authorization-kubeconfig",
+			"bind-address",
+			"kubeconfig",
+			"secure-port",
+		},
+		removed: map[string]uint{
+			"policy-config-file":       23,
+			"policy-configmap":         23,
+			"use-legacy-policy-config": 23,
+			"address":                  24,
+			"port":                     24,
+		},
+	},
+	kubeletComponent: {
+		managed: []string{
+			"client-ca-file",
+			"cloud-config",
+			"cloud-provider",
+			"cluster-dns",
+			"cluster-domain",
+			"cni-bin-dir",
+			"cni-conf-dir",
+			"container-runtime",
+			"container-runtime-endpoint",
+			"hostname-override",
+			"kubeconfig",
+			"network-plugin",
+			"node-ip",
+			"pod-infra-container-image",
+			"root-dir",
+			"tls-cert-file",
+			"tls-private-key-file",
+		},
+		removed: map[string]uint{
+			"cadvisor-port":                12,
+			"allow-privileged":             15,
+			"docker-endpoint":              24,
+			"image-pull-progress-deadline": 24,
+		},
+	},
+	kubeProxyComponent: {
+		managed: []string{
+			"cluster-cidr",
+			"hostname-override",
+			"kubeconfig",
+		},
+		removed: map[string]uint{
+			"resource-container": 16,
+		},
+	},
+	etcdComponent: {
+		managed: []string{
+			"advertise-client-urls",
+			"cert-file",
+			"client-cert-auth",
+			"data-dir",
+			"initial-advertise-peer-urls",
+			"initial-cluster",
+			"initial-cluster-state",
+			"initial-cluster-token",
+			"key-file",
+			"listen-client-urls",
+			"listen-metrics-urls",
+			"listen-peer-urls",
+			"name",
+			"peer-cert-file",
+			"peer-client-cert-auth",
+			"peer-key-file",
+			"peer-trusted-ca-file",
+			"trusted-ca-file",
+		},
+	},
+}
+
+// rkeSSLFiles maps the RKE1 certificate files to their RKE2 equivalent
+// relative to the server data dir.
+var rkeSSLFiles = map[string]string{
+	"kube-ca.pem":                             "tls/server-ca.crt",
+	"kube-ca-key.pem":                         "tls/server-ca.key",
+	"kube-service-account-token.pem":          "tls/service.crt",
+	"kube-service-account-token-key.pem":      "tls/service.key",
+	"kube-apiserver-requestheader-ca.pem":     "tls/request-header-ca.crt",
+	"kube-apiserver-requestheader-ca-key.pem": "tls/request-header-ca.key",
+}
+
+// translateArgs filters the extra args of an RKE1 component and converts them to
+// the RKE2 list form, flags that are managed by RKE2 or removed in the target
+// kubernetes version are dropped and returned along with the reason.
+func translateArgs(component string, extraArgs map[string]string, k8sVersion, dataDir string) ([]string, map[string]string) {
+	translation := componentArgs[component]
+	// an unparsable version only disables the removed flags check
+	targetVersion, _ := version.ParseGeneric(k8sVersion)
+
+	args := []string{}
+	dropped := map[string]string{}
+	for k, v := range extraArgs {
+		flag := strings.TrimLeft(k, "-")
+		if isManagedArg(translation, flag) {
+			dropped[flag] = "managed by RKE2"
+			continue
+		}
+		if minor, ok := translation.removed[flag]; ok && targetVersion != nil && targetVersion.Minor() >= minor {
+			dropped[flag] = fmt.Sprintf("removed in kubernetes v1.%d", minor)
+			continue
+		}
+		if v == "" {
+			args = append(args, flag)
+			continue
+		}
+		args = append(args, flag+"="+rewriteSSLPaths(v, dataDir))
+	}
+	sort.Strings(args)
+
+	return args, dropped
+}
+
+func isManagedArg(translation argsTranslation, flag string) bool {
+	for _, managed := range translation.managed {
+		if managed == flag {
+			return true
+		}
+	}
+	return false
+}
+
+// rewriteSSLPaths replaces the paths of RKE1 certificate files with their RKE2
+// equivalent, values can be comma separated lists of paths.
+func rewriteSSLPaths(value, dataDir string) string {
+	if !strings.Contains(value, rkeSSLDir) {
+		return value
+	}
+	parts := strings.Split(value, ",")
+	for i, part := range parts {
+		if filepath.Dir(part) != rkeSSLDir {
+			continue
+		}
+		if rke2File, ok := rkeSSLFiles[filepath.Base(part)]; ok {
+			parts[i] = filepath.Join(dataDir, "server", rke2File)
+			continue
+		}
+		logrus.Warnf("Path %s is not managed by RKE2 and will still point to the RKE1 ssl directory", part)
+	}
+	return strings.Join(parts, ",")
+}
+
+// setComponentArgs translates the extra args of a component and adds them to
+// the RKE2 config under the given flag name, dropped flags are reported.
+func setComponentArgs(argsMap map[string]interface{}, flagName, component string, extraArgs map[string]string, k8sVersion, dataDir string) {
+	if len(extraArgs) == 0 {
+		return
+	}
+	args, dropped := translateArgs(component, extraArgs, k8sVersion, dataDir)
+	for _, flag := range sortedKeys(dropped) {
+		logrus.Warnf("Dropping %s argument %s: %s", component, flag, dropped[flag])
+	}
+	if len(args) > 0 {
+		argsMap[flagName] = args
+	}
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,144 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTranslateArgs(t *testing.T) {
+	tests := []struct {
+		name        string
+		component   string
+		extraArgs   map[string]string
+		k8sVersion  string
+		wantArgs    []string
+		wantDropped map[string]string
+	}{
+		{
+			name:      "managed flags are dropped",
+			component: kubeAPIServerComponent,
+			extraArgs: map[string]string{
+				"tls-cert-file":  "/etc/kubernetes/ssl/kube-apiserver.pem",
+				"etcd-servers":   "https://10.0.0.1:2379",
+				"audit-log-path": "/var/log/audit.log",
+			},
+			k8sVersion: "v1.21.14+rke2r1",
+			wantArgs:   []string{"audit-log-path=/var/log/audit.log"},
+			wantDropped: map[string]string{
+				"tls-cert-file": "managed by RKE2",
+				"etcd-servers":  "managed by RKE2",
+			},
+		},
+		{
+			name:      "leading dashes and empty values",
+			component: kubeletComponent,
+			extraArgs: map[string]string{
+				"--max-pods": "250",
+				"-v":         "2",
+				"profiling":  "",
+				"kubeconfig": "/etc/kubernetes/ssl/kubecfg-kube-node.yaml",
+			},
+			k8sVersion: "v1.21.14+rke2r1",
+			wantArgs:   []string{"max-pods=250", "profiling", "v=2"},
+			wantDropped: map[string]string{
+				"kubeconfig": "managed by RKE2",
+			},
+		},
+		{
+			name:      "ssl paths are renamed to the RKE2 files",
+			component: kubeAPIServerComponent,
+			extraArgs: map[string]string{
+				"oidc-ca-file":         "/etc/kubernetes/ssl/kube-ca.pem",
+				"requestheader-cafile": "/etc/kubernetes/ssl/kube-apiserver-requestheader-ca.pem,/etc/kubernetes/ssl/kube-ca.pem",
+				"audit-policy-file":    "/etc/kubernetes/audit-policy.yaml",
+			},
+			k8sVersion: "v1.21.14+rke2r1",
+			wantArgs: []string{
+				"audit-policy-file=/etc/kubernetes/audit-policy.yaml",
+				"oidc-ca-file=/var/lib/rancher/rke2/server/tls/server-ca.crt",
+				"requestheader-cafile=/var/lib/rancher/rke2/server/tls/request-header-ca.crt,/var/lib/rancher/rke2/server/tls/server-ca.crt",
+			},
+			wantDropped: map[string]string{},
+		},
+		{
+			name:      "flags removed in the target version are dropped",
+			component: kubeAPIServerComponent,
+			extraArgs: map[string]string{
+				"insecure-port":     "0",
+				"kubelet-https":     "true",
+				"enable-swagger-ui": "true",
+			},
+			k8sVersion: "v1.24.17+rke2r1",
+			wantArgs:   []string{},
+			wantDropped: map[string]string{
+				"insecure-port":     "removed in kubernetes v1.24",
+				"kubelet-https":     "removed in kubernetes v1.22",
+				"enable-swagger-ui": "removed in kubernetes v1.14",
+			},
+		},
+		{
+			name:      "flags still served by the target version are kept",
+			component: kubeAPIServerComponent,
+			extraArgs: map[string]string{
+				"insecure-port": "0",
+				"kubelet-https": "true",
+			},
+			k8sVersion:  "v1.21.14+rke2r1",
+			wantArgs:    []string{"insecure-port=0", "kubelet-https=true"},
+			wantDropped: map[string]string{},
+		},
+		{
+			name:      "unknown target version only skips the removed flags check",
+			component: kubeSchedulerComponent,
+			extraArgs: map[string]string{
+				"address":    "0.0.0.0",
+				"kubeconfig": "/etc/kubernetes/ssl/kubecfg-kube-scheduler.yaml",
+			},
+			k8sVersion: "",
+			wantArgs:   []string{"address=0.0.0.0"},
+			wantDropped: map[string]string{
+				"kubeconfig": "managed by RKE2",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args, dropped := translateArgs(tt.component, tt.extraArgs, tt.k8sVersion, "/var/lib/rancher/rke2")
+			if !reflect.DeepEqual(args, tt.wantArgs) {
+				t.Errorf("args = %v, want %v", args, tt.wantArgs)
+			}
+			if !reflect.DeepEqual(dropped, tt.wantDropped) {
+				t.Errorf("dropped = %v, want %v", dropped, tt.wantDropped)
+			}
+		})
+	}
+}
+
+func TestRewriteSSLPaths(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{
+			value: "/etc/kubernetes/ssl/kube-service-account-token.pem",
+			want:  "/var/lib/rancher/rke2/server/tls/service.crt",
+		},
+		{
+			value: "/etc/kubernetes/ssl/kube-service-account-token-key.pem",
+			want:  "/var/lib/rancher/rke2/server/tls/service.key",
+		},
+		{
+			value: "/etc/kubernetes/ssl/kube-ca-key.pem,/etc/kubernetes/ssl/custom.pem",
+			want:  "/var/lib/rancher/rke2/server/tls/server-ca.key,/etc/kubernetes/ssl/custom.pem",
+		},
+		{
+			value: "/etc/kubernetes/ssl/certs/kube-ca.pem",
+			want:  "/etc/kubernetes/ssl/certs/kube-ca.pem",
+		},
+	}
+	for _, tt := range tests {
+		if got := rewriteSSLPaths(tt.value, "/var/lib/rancher/rke2"); got != tt.want {
+			t.Errorf("rewriteSSLPaths(%s) = %s, want %s", tt.value, got, tt.want)
+		}
+	}
+}
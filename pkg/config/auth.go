@@ -0,0 +1,95 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rancher/k3s/pkg/cli/cmds"
+	"github.com/rancher/rke/types"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	tlsSANFlag                 = "tls-san"
+	authnWebhookConfigFileRKE2 = "/etc/rancher/rke2/kube-api-authn-webhook.yaml"
+
+	webhookAuthnStrategy = "webhook"
+	noneAuthzMode        = "none"
+
+	authnWebhookConfigFileArg = "authentication-token-webhook-config-file"
+	authnWebhookCacheTTLArg   = "authentication-token-webhook-cache-ttl"
+	authzModeArg              = "authorization-mode"
+
+	kubeAPIServerExtraMountFlag = "kube-apiserver-extra-mount"
+)
+
+// migrateAuthConfig translates the RKE1 authentication and authorization
+// config into RKE2 tls-san and kube-apiserver args.
+func migrateAuthConfig(rkeConfig *types.RancherKubernetesEngineConfig, args map[string]interface{}) error {
+	authn := rkeConfig.Authentication
+	if len(authn.SANs) > 0 {
+		args[tlsSANFlag] = authn.SANs
+	}
+
+	if hasAuthnStrategy(authn.Strategy, webhookAuthnStrategy) {
+		if authn.Webhook == nil || authn.Webhook.ConfigFile == "" {
+			logrus.Warnf("Webhook authentication strategy is set without a webhook config file, skipping")
+		} else {
+			logrus.Infof("Migrating webhook authentication config to %s", authnWebhookConfigFileRKE2)
+			if err := os.MkdirAll(filepath.Dir(authnWebhookConfigFileRKE2), 0755); err != nil {
+				return err
+			}
+			// RKE1 keeps the content of the webhook kubeconfig in the state
+			if err := os.WriteFile(authnWebhookConfigFileRKE2, []byte(authn.Webhook.ConfigFile), 0600); err != nil {
+				return err
+			}
+			// the static kube-apiserver pod only mounts the RKE2 data dir
+			appendArgs(args, kubeAPIServerExtraMountFlag, authnWebhookConfigFileRKE2+":"+authnWebhookConfigFileRKE2+":ro")
+			appendArgs(args, cmds.ExtraAPIArgs.Name, authnWebhookConfigFileArg+"="+authnWebhookConfigFileRKE2)
+			if authn.Webhook.CacheTimeout != "" {
+				appendArgs(args, cmds.ExtraAPIArgs.Name, authnWebhookCacheTTLArg+"="+authn.Webhook.CacheTimeout)
+			}
+		}
+	}
+
+	if !rbacEnabled(rkeConfig) {
+		logrus.Warnf("Authorization mode is set to none in RKE state, kube-apiserver will allow all requests")
+		if !hasArg(args, cmds.ExtraAPIArgs.Name, authzModeArg) {
+			appendArgs(args, cmds.ExtraAPIArgs.Name, authzModeArg+"=AlwaysAllow")
+		}
+	}
+	return nil
+}
+
+// rbacEnabled returns false only when RKE1 explicitly disabled authorization,
+// rbac is the RKE1 default when the mode is unset.
+func rbacEnabled(rkeConfig *types.RancherKubernetesEngineConfig) bool {
+	return rkeConfig.Authorization.Mode != noneAuthzMode
+}
+
+func hasAuthnStrategy(strategies, strategy string) bool {
+	for _, s := range strings.Split(strategies, "|") {
+		if strings.TrimSpace(s) == strategy {
+			return true
+		}
+	}
+	return false
+}
+
+// appendArgs adds values to a list form RKE2 arg such as kube-apiserver-arg.
+func appendArgs(args map[string]interface{}, flagName string, values ...string) {
+	existing, _ := args[flagName].([]string)
+	args[flagName] = append(existing, values...)
+}
+
+// hasArg checks if a list form RKE2 arg already sets the given flag.
+func hasArg(args map[string]interface{}, flagName, flag string) bool {
+	existing, _ := args[flagName].([]string)
+	for _, arg := range existing {
+		if arg == flag || strings.HasPrefix(arg, flag+"=") {
+			return true
+		}
+	}
+	return false
+}
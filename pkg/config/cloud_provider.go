@@ -3,8 +3,12 @@ package config
 import (
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 
+	"github.com/rancher/rke/cloudprovider"
 	"github.com/rancher/rke/cluster"
+	"github.com/rancher/rke/types"
 	"github.com/sirupsen/logrus"
 )
 
@@ -13,17 +17,49 @@ const (
 	cloudConfigFileRKE2     = "/etc/rancher/rke2/cloud.conf"
 	cloudProviderNameFlag   = "cloud-provider-name"
 	cloudProviderConfigFlag = "cloud-provider-config"
+
+	externalCloudProvider       = "external"
+	rancherVsphereCloudProvider = "rancher-vsphere"
+	rancherVsphereCPI           = "rancher-vsphere-cpi"
+	rancherVsphereCSI           = "rancher-vsphere-csi"
+	defaultVspherePort          = 443
 )
 
-func migrateCloudProviders(fullState *cluster.FullState, args map[string]string) error {
-	cloudProviderName := fullState.CurrentState.RancherKubernetesEngineConfig.CloudProvider.Name
-	if cloudProviderName == "" {
+func migrateCloudProviders(fullState *cluster.FullState, args map[string]interface{}, dataDir string, server bool) error {
+	cloudProviderConfig := fullState.CurrentState.RancherKubernetesEngineConfig.CloudProvider
+	if cloudProviderConfig.Name == "" {
 		return nil
 	}
 	logrus.Infof("Migrating RKE cloud provider config")
+	if cloudProviderConfig.Name == externalCloudProvider {
+		return migrateExternalCloudProvider(cloudProviderConfig, args, dataDir, server)
+	}
+
+	provider, err := cloudprovider.InitCloudProvider(cloudProviderConfig)
+	if err != nil {
+		return err
+	}
+	if provider == nil {
+		logrus.Warnf("Cloud provider %s is not recognized, skipping cloud provider migration", cloudProviderConfig.Name)
+		return nil
+	}
 	// add cloud config name to the args
-	args[cloudProviderNameFlag] = cloudProviderName
-	if _, err := os.Stat(cloudConfigFileRKE1); err == nil {
+	args[cloudProviderNameFlag] = provider.GetName()
+
+	// render the cloud config from the typed provider config the same way RKE1 does
+	cloudConfig, err := provider.GenerateCloudConfigFile()
+	if err != nil {
+		return err
+	}
+	if cloudConfig != "" {
+		if err := os.MkdirAll(filepath.Dir(cloudConfigFileRKE2), 0700); err != nil {
+			return err
+		}
+		if err := os.WriteFile(cloudConfigFileRKE2, []byte(cloudConfig), 0600); err != nil {
+			return err
+		}
+		args[cloudProviderConfigFlag] = cloudConfigFileRKE2
+	} else if _, err := os.Stat(cloudConfigFileRKE1); err == nil {
 		// copy cloud config file to the rke2 location
 		if err := copy(cloudConfigFileRKE1, cloudConfigFileRKE2); err != nil {
 			return err
@@ -34,6 +70,87 @@ func migrateCloudProviders(fullState *cluster.FullState, args map[string]string)
 	return nil
 }
 
+// migrateExternalCloudProvider configures the RKE2 out-of-tree cloud provider,
+// vsphere is deployed by RKE2 through the rancher-vsphere cpi and csi charts.
+func migrateExternalCloudProvider(cloudProviderConfig types.CloudProvider, args map[string]interface{}, dataDir string, server bool) error {
+	if cloudProviderConfig.VsphereCloudProvider == nil {
+		args[cloudProviderNameFlag] = externalCloudProvider
+		logrus.Warnf("Manual step required: deploy the cloud controller manager of the external cloud provider on RKE2")
+		return nil
+	}
+	args[cloudProviderNameFlag] = rancherVsphereCloudProvider
+	if !server {
+		return nil
+	}
+
+	logrus.Infof("Migrating external vSphere cloud provider config to %s and %s charts", rancherVsphereCPI, rancherVsphereCSI)
+	vCenter := getVsphereVCenterConfig(cloudProviderConfig.VsphereCloudProvider)
+	cpiConfig, err := toHelmChartConfig(rancherVsphereCPI, VsphereCPIConfig{VCenter: vCenter})
+	if err != nil {
+		return err
+	}
+	csiConfig, err := toHelmChartConfig(rancherVsphereCSI, VsphereCSIConfig{VCenter: vCenter})
+	if err != nil {
+		return err
+	}
+
+	manifestsDir := manifestsDir(dataDir)
+	if err := os.MkdirAll(manifestsDir, 0700); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(manifestsDir, rancherVsphereCPI+"-config.yaml"), cpiConfig, 0600); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(manifestsDir, rancherVsphereCSI+"-config.yaml"), csiConfig, 0600)
+}
+
+// getVsphereVCenterConfig returns the first virtual center of the vsphere config,
+// per virtual center settings override the global ones like in the cloud config.
+func getVsphereVCenterConfig(vsphereConfig *types.VsphereCloudProvider) VsphereVCenterConfig {
+	global := vsphereConfig.Global
+	vCenter := VsphereVCenterConfig{
+		Host:        global.VCenterIP,
+		Port:        defaultVspherePort,
+		Username:    global.User,
+		Password:    global.Password,
+		Datacenters: global.Datacenters,
+	}
+	if global.VCenterPort != "" {
+		if port, err := strconv.Atoi(global.VCenterPort); err == nil {
+			vCenter.Port = port
+		}
+	}
+	if global.InsecureFlag {
+		vCenter.InsecureFlag = "1"
+	}
+
+	hosts := make([]string, 0, len(vsphereConfig.VirtualCenter))
+	for host := range vsphereConfig.VirtualCenter {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	if len(hosts) > 1 {
+		logrus.Warnf("Only virtual center %s is migrated, the rancher-vsphere charts support a single virtual center", hosts[0])
+	}
+	if len(hosts) > 0 {
+		vc := vsphereConfig.VirtualCenter[hosts[0]]
+		vCenter.Host = hosts[0]
+		if vc.User != "" {
+			vCenter.Username = vc.User
+		}
+		if vc.Password != "" {
+			vCenter.Password = vc.Password
+		}
+		if vc.Datacenters != "" {
+			vCenter.Datacenters = vc.Datacenters
+		}
+		if port, err := strconv.Atoi(vc.Port); err == nil {
+			vCenter.Port = port
+		}
+	}
+	return vCenter
+}
+
 // copy will copy the src file to destination and will create the base directory
 // of the destination first.
 func copy(src, dest string) error {
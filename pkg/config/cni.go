@@ -5,28 +5,78 @@ import (
 	"errors"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 
 	helmv1 "github.com/k3s-io/helm-controller/pkg/apis/helm.cattle.io/v1"
 	"github.com/rancher/rke/cluster"
+	"github.com/rancher/rke/types"
 	"github.com/sirupsen/logrus"
+	appsv1 "k8s.io/api/apps/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"sigs.k8s.io/yaml"
 )
 
 const (
-	canalFlannelBackendType      = "canal_flannel_backend_type"
-	canalFlannelFlexVolPluginDir = "canal_flex_volume_plugin_dir"
-	canalFlannelInterface        = "canal_iface"
+	canalFlannelBackendType          = "canal_flannel_backend_type"
+	canalFlannelBackendPort          = "canal_flannel_backend_port"
+	canalFlannelBackendVNI           = "canal_flannel_backend_vni"
+	canalFlannelBackendDirectRouting = "canal_flannel_backend_directrouting"
+	canalFlannelFlexVolPluginDir     = "canal_flex_volume_plugin_dir"
+	canalFlannelInterface            = "canal_iface"
 
 	calicoFlexVolumePluginDir = "calico_flex_volume_plugin_dir"
+	calicoCloudProvider       = "calico_cloud_provider"
+
+	flannelBackendType          = "flannel_backend_type"
+	flannelBackendPort          = "flannel_backend_port"
+	flannelBackendVNI           = "flannel_backend_vni"
+	flannelBackendDirectRouting = "flannel_backend_directrouting"
+	flannelInterface            = "flannel_iface"
+
+	// RKE1 calico uses IPIP encapsulation and the calico default block size
+	calicoIPPoolEncapsulation = "IPIP"
+	calicoIPPoolBlockSize     = 26
 )
 
+// unmappedNetworkOptions have no equivalent in the RKE2 cni charts, they are
+// reported as manual steps along with what RKE2 does instead.
+var unmappedNetworkOptions = map[string]string{
+	"canal_priority_class_name":                   "rke2-canal always runs with the system-node-critical priority class",
+	"canal_autoscaler_priority_class_name":        "rke2-canal does not deploy an autoscaler",
+	"calico_node_priority_class_name":             "the tigera operator runs calico-node with the system-node-critical priority class",
+	"calico_kube_controllers_priority_class_name": "the tigera operator runs calico-kube-controllers with the system-cluster-critical priority class",
+	"flannel_priority_class_name":                 "rke2-canal always runs with the system-node-critical priority class",
+	calicoCloudProvider:                           "set the calico environment of the cloud in the rke2-calico HelmChartConfig",
+}
+
+// TargetCNI returns the RKE2 cni for the RKE1 network plugin, plugins that are
+// not packaged by RKE2 are kept running by RKE2 with the none cni.
+func TargetCNI(plugin, flannelTarget string) string {
+	switch plugin {
+	case canalCNI, calicoCNI, noneCNI:
+		return plugin
+	case flannelCNI:
+		if flannelTarget == noneCNI {
+			return noneCNI
+		}
+		return canalCNI
+	case weaveCNI, aciCNI:
+		return noneCNI
+	}
+	return ""
+}
+
+// KeepNetworkAddon returns true if the RKE1 network plugin should be kept
+// running after the migration instead of being replaced by an RKE2 cni.
+func KeepNetworkAddon(plugin, flannelTarget string) bool {
+	return plugin != "" && plugin != noneCNI && TargetCNI(plugin, flannelTarget) == noneCNI
+}
+
 // MigrateCNIConfig should read the cni plugin specific configuration and copy it
-// as a helm chart config to RKE2 and then save it to the manifest dir, this
-// currently only works for canal installation because calico tigera operator
-// doesnt contain a lot of customization
-func MigrateCNIConfig(ctx context.Context, fullState *cluster.FullState, dataDir string) error {
+// as a helm chart config to RKE2 and then save it to the manifest dir, flannel
+// is migrated to canal while other plugins are left to the operator
+func MigrateCNIConfig(ctx context.Context, fullState *cluster.FullState, dataDir, flannelTarget string) error {
 	var (
 		helmChartConfig []byte
 		err             error
@@ -36,19 +86,33 @@ func MigrateCNIConfig(ctx context.Context, fullState *cluster.FullState, dataDir
 		return nil
 	}
 
+	reportUnmappedNetworkOptions(networkConfig)
+	// the pod cidr must reach the cni pools, otherwise calico ipam creates a
+	// default pool conflicting with the one of RKE1
+	podCIDR := fullState.CurrentState.RancherKubernetesEngineConfig.Services.KubeController.ClusterCIDR
+
 	// migrate canal config to helm chart
 	if networkConfig.Plugin == canalCNI {
 		logrus.Info("Canal CNI plugin is used by RKE1, migrating config to RKE2")
 		canalCfg := CanalConfig{
-			Calico: map[string]string{
-				"vethuMTU":            strconv.Itoa(networkConfig.MTU),
-				"flexVolumePluginDir": networkConfig.Options[canalFlannelFlexVolPluginDir],
-			},
-			Flannel: map[string]string{
-				"backend": networkConfig.Options[canalFlannelBackendType],
-				"iface":   networkConfig.Options[canalFlannelInterface],
-			},
+			PodCIDR: podCIDR,
+			Calico:  map[string]string{},
+			Flannel: flannelValues(networkConfig, map[string]string{
+				"backend":       canalFlannelBackendType,
+				"backendPort":   canalFlannelBackendPort,
+				"vni":           canalFlannelBackendVNI,
+				"directRouting": canalFlannelBackendDirectRouting,
+				"iface":         canalFlannelInterface,
+			}),
+		}
+		if networkConfig.MTU > 0 {
+			canalCfg.Calico["vethuMTU"] = strconv.Itoa(networkConfig.MTU)
 		}
+		setIfNotEmptyValue(canalCfg.Calico, "flexVolumePluginDir", networkConfig.Options[canalFlannelFlexVolPluginDir])
+		if networkConfig.CanalNetworkProvider != nil && networkConfig.CanalNetworkProvider.Iface != "" {
+			canalCfg.Flannel["iface"] = networkConfig.CanalNetworkProvider.Iface
+		}
+		canalCfg.UpdateStrategy = networkUpdateStrategy(networkConfig)
 		helmChartConfig, err = toHelmChartConfig("rke2-"+networkConfig.Plugin, canalCfg)
 		if err != nil {
 			return err
@@ -58,23 +122,56 @@ func MigrateCNIConfig(ctx context.Context, fullState *cluster.FullState, dataDir
 		calicoCfg := CalicoConfig{
 			Installation: CalicoInstallationSpec{
 				FlexVolumePath: networkConfig.Options[calicoFlexVolumePluginDir],
-				CalicoNetwork: map[string]int{
-					"mtu": networkConfig.MTU,
+				CalicoNetwork: CalicoNetworkSpec{
+					MTU: networkConfig.MTU,
 				},
 				ControlPlaneNodeSelector: networkConfig.NodeSelector,
+				ControlPlaneTolerations:  networkConfig.Tolerations,
 			},
 		}
+		if podCIDR != "" {
+			calicoCfg.Installation.CalicoNetwork.IPPools = []CalicoIPPool{
+				{
+					CIDR:          podCIDR,
+					Encapsulation: calicoIPPoolEncapsulation,
+					NATOutgoing:   "Enabled",
+					BlockSize:     calicoIPPoolBlockSize,
+				},
+			}
+		}
+		calicoCfg.Installation.NodeUpdateStrategy = networkUpdateStrategy(networkConfig)
 		helmChartConfig, err = toHelmChartConfig("rke2-"+networkConfig.Plugin, calicoCfg)
 		if err != nil {
 			return err
 		}
+	} else if networkConfig.Plugin == flannelCNI && TargetCNI(flannelCNI, flannelTarget) == canalCNI {
+		logrus.Info("Flannel CNI plugin is used by RKE1, migrating config to RKE2 canal")
+		canalCfg := CanalConfig{
+			PodCIDR:        podCIDR,
+			UpdateStrategy: networkUpdateStrategy(networkConfig),
+			Flannel: flannelValues(networkConfig, map[string]string{
+				"backend":       flannelBackendType,
+				"backendPort":   flannelBackendPort,
+				"vni":           flannelBackendVNI,
+				"directRouting": flannelBackendDirectRouting,
+				"iface":         flannelInterface,
+			}),
+		}
+		if networkConfig.FlannelNetworkProvider != nil && networkConfig.FlannelNetworkProvider.Iface != "" {
+			canalCfg.Flannel["iface"] = networkConfig.FlannelNetworkProvider.Iface
+		}
+		helmChartConfig, err = toHelmChartConfig("rke2-"+canalCNI, canalCfg)
+		if err != nil {
+			return err
+		}
+		logrus.Warnf("Manual step required: canal adds calico network policy enforcement on top of flannel, review existing NetworkPolicy objects before cut-over")
 	} else {
-		logrus.Infof("network plugin is not recognized as rke2 network plugin")
+		reportUnmanagedCNI(networkConfig.Plugin, flannelTarget)
 		return nil
 	}
 
 	manifestsDir := manifestsDir(dataDir)
-	manifestFile := filepath.Join(manifestsDir, "rke2-"+networkConfig.Plugin+"-config.yaml")
+	manifestFile := filepath.Join(manifestsDir, "rke2-"+TargetCNI(networkConfig.Plugin, flannelTarget)+"-config.yaml")
 	err = os.MkdirAll(manifestsDir, 0700)
 	if err != nil {
 		return err
@@ -84,6 +181,69 @@ func MigrateCNIConfig(ctx context.Context, fullState *cluster.FullState, dataDir
 	return os.WriteFile(manifestFile, helmChartConfig, 0600)
 }
 
+// flannelValues translates the RKE1 flannel options to the flannel values of
+// the rke2-canal chart, options maps each value to its RKE1 option key.
+func flannelValues(networkConfig types.NetworkConfig, options map[string]string) map[string]string {
+	values := map[string]string{}
+	for value, option := range options {
+		setIfNotEmptyValue(values, value, networkConfig.Options[option])
+	}
+	return values
+}
+
+// networkUpdateStrategy translates the RKE1 network plugin update strategy to
+// the strategy of the cni DaemonSet.
+func networkUpdateStrategy(networkConfig types.NetworkConfig) *appsv1.DaemonSetUpdateStrategy {
+	if networkConfig.UpdateStrategy == nil {
+		return nil
+	}
+	return &appsv1.DaemonSetUpdateStrategy{
+		Type:          networkConfig.UpdateStrategy.Strategy,
+		RollingUpdate: networkConfig.UpdateStrategy.RollingUpdate,
+	}
+}
+
+// reportUnmappedNetworkOptions reports the RKE1 network options which have no
+// equivalent in the RKE2 cni charts as manual steps.
+func reportUnmappedNetworkOptions(networkConfig types.NetworkConfig) {
+	options := make([]string, 0, len(unmappedNetworkOptions))
+	for option := range unmappedNetworkOptions {
+		options = append(options, option)
+	}
+	sort.Strings(options)
+	for _, option := range options {
+		if v, ok := networkConfig.Options[option]; ok && v != "" {
+			logrus.Warnf("Manual step required: network option %s=%s can not be migrated, %s", option, v, unmappedNetworkOptions[option])
+		}
+	}
+	if networkConfig.CalicoNetworkProvider != nil && networkConfig.CalicoNetworkProvider.CloudProvider != "" {
+		logrus.Warnf("Manual step required: calico cloud provider %s can not be migrated, %s", networkConfig.CalicoNetworkProvider.CloudProvider, unmappedNetworkOptions[calicoCloudProvider])
+	}
+}
+
+func setIfNotEmptyValue(values map[string]string, key, value string) {
+	if value != "" {
+		values[key] = value
+	}
+}
+
+// reportUnmanagedCNI logs what the operator still has to do when the RKE1
+// network plugin is not replaced by an RKE2 packaged cni.
+func reportUnmanagedCNI(plugin, flannelTarget string) {
+	switch {
+	case plugin == noneCNI:
+		logrus.Warnf("No network plugin was deployed by RKE1, RKE2 is configured with cni: none")
+		logrus.Warnf("Manual step required: deploy the network plugin used by the cluster on RKE2")
+	case KeepNetworkAddon(plugin, flannelTarget):
+		logrus.Warnf("Network plugin %s is not migrated to an RKE2 cni, RKE2 is configured with cni: none", plugin)
+		logrus.Warnf("The RKE1 %s DaemonSet is kept running and is no longer managed by RKE or RKE2", plugin)
+		logrus.Warnf("Manual step required: take over the %s manifests from the %s ConfigMap to manage its upgrades and configuration", plugin, networkConfigMap)
+		logrus.Warnf("Manual step required: make sure the %s images are pullable by containerd on every node", plugin)
+	default:
+		logrus.Warnf("Network plugin %s is not recognized as rke2 network plugin", plugin)
+	}
+}
+
 func toHelmChartConfig(helmChartName string, values interface{}) ([]byte, error) {
 	var (
 		valuesYaml []byte
@@ -125,6 +285,24 @@ func toHelmChartConfig(helmChartName string, values interface{}) ([]byte, error)
 		if err != nil {
 			return nil, err
 		}
+	} else if helmChartName == rancherVsphereCPI {
+		valuesConfig, ok := values.(VsphereCPIConfig)
+		if !ok {
+			return nil, errors.New("invalid RKE vSphere CPI Config")
+		}
+		valuesYaml, err = yaml.Marshal(&valuesConfig)
+		if err != nil {
+			return nil, err
+		}
+	} else if helmChartName == rancherVsphereCSI {
+		valuesConfig, ok := values.(VsphereCSIConfig)
+		if !ok {
+			return nil, errors.New("invalid RKE vSphere CSI Config")
+		}
+		valuesYaml, err = yaml.Marshal(&valuesConfig)
+		if err != nil {
+			return nil, err
+		}
 	} else if helmChartName == "rke2-"+metricsServer {
 		valuesConfig, ok := values.(MetricsServerConfig)
 		if !ok {
@@ -5,30 +5,30 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"html/template"
-	"net/url"
 	"os"
 	"path/filepath"
-	"strings"
 
 	"github.com/rancher/k3s/pkg/cli/cmds"
+	daemonconfig "github.com/rancher/k3s/pkg/daemons/config"
 	"github.com/rancher/rke/cluster"
 	"github.com/rancher/rke/pki"
-	"github.com/rancher/wharfie/pkg/registries"
+	"github.com/rancher/rke/types"
 	"github.com/sirupsen/logrus"
 	"k8s.io/client-go/tools/clientcmd"
-	"sigs.k8s.io/yaml"
 )
 
 const (
-	configDir           = "/etc/rancher/rke2/config.yaml.d"
-	privateRegistryPath = "/etc/rancher/rke2/registries.yaml"
-	kubeProxyConfig     = "kubeproxy.kubeconfig"
-	rkeClusterConfig    = "rke2-cluster-config"
-	registryFlagParts   = 4
+	configDir        = "/etc/rancher/rke2/config.yaml.d"
+	kubeProxyConfig  = "kubeproxy.kubeconfig"
+	rkeClusterConfig = "rke2-cluster-config"
+	disableFlag      = "disable"
 
 	calicoCNI     = "calico"
 	canalCNI      = "canal"
+	flannelCNI    = "flannel"
 	weaveCNI      = "weave"
+	aciCNI        = "aci"
+	noneCNI       = "none"
 	nginxIngress  = "ingress-nginx"
 	coredns       = "coredns"
 	metricsServer = "metrics-server"
@@ -58,29 +58,39 @@ users:
 `))
 )
 
-func ExportClusterConfiguration(ctx context.Context, fullState *cluster.FullState, nodeName string, server bool, registries []string) error {
+// ExportClusterConfiguration writes the RKE2 config of the node, the args are
+// translated for the target kubernetes version which defaults to the RKE one.
+func ExportClusterConfiguration(ctx context.Context, fullState *cluster.FullState, control *daemonconfig.Control, node *types.RKEConfigNode, nodeName, dataDir, flannelTarget, k8sVersion string, server bool, registries []string) error {
 	logrus.Infof("Migrating cluster configuration from RKE state")
+	if k8sVersion == "" {
+		k8sVersion = fullState.CurrentState.RancherKubernetesEngineConfig.Version
+	}
 	var (
-		args map[string]string
+		args map[string]interface{}
 		err  error
 	)
 	if server {
-		args, err = getServerConfig(fullState, nodeName)
+		args, err = getServerConfig(fullState, control, node, nodeName, dataDir, flannelTarget, k8sVersion)
 		if err != nil {
 			return err
 		}
 	} else {
-		args, err = getAgentConfig(fullState, nodeName)
+		args, err = getAgentConfig(fullState, node, nodeName, dataDir, k8sVersion)
 		if err != nil {
 			return err
 		}
 	}
 
-	if err := migrateCloudProviders(fullState, args); err != nil {
+	if err := migrateCloudProviders(fullState, args, dataDir, server); err != nil {
 		return err
 	}
 
-	if err := configurePrivateRegistries(ctx, fullState, registries); err != nil {
+	if err := configurePrivateRegistries(ctx, fullState, registries, k8sVersion, args); err != nil {
+		return err
+	}
+	migrateSystemImages(fullState.CurrentState.RancherKubernetesEngineConfig, args, server)
+
+	if err := migrateProxyEnv(fullState, server); err != nil {
 		return err
 	}
 
@@ -93,15 +103,21 @@ func ExportClusterConfiguration(ctx context.Context, fullState *cluster.FullStat
 		return err
 	}
 
+	// the config holds the etcd S3 credentials, it is only readable by root
 	configPath := filepath.Join(configDir, "10-migration.yaml")
-	return os.WriteFile(configPath, data, 0644)
+	if err := os.WriteFile(configPath, data, 0600); err != nil {
+		return err
+	}
+	// the mode of a config written by a previous run is not changed by the write
+	return os.Chmod(configPath, 0600)
 }
 
 // getServerConfig constructs an rke2 config file from rke1 server options.
-func getServerConfig(fullState *cluster.FullState, nodeName string) (map[string]string, error) {
-	services := fullState.CurrentState.RancherKubernetesEngineConfig.Services
+func getServerConfig(fullState *cluster.FullState, control *daemonconfig.Control, node *types.RKEConfigNode, nodeName, dataDir, flannelTarget, k8sVersion string) (map[string]interface{}, error) {
+	rkeConfig := fullState.CurrentState.RancherKubernetesEngineConfig
+	services := rkeConfig.Services
 
-	argsMap := map[string]string{
+	argsMap := map[string]interface{}{
 		cmds.ServiceCIDR.Name:          services.KubeAPI.ServiceClusterIPRange,
 		cmds.ClusterCIDR.Name:          services.KubeController.ClusterCIDR,
 		cmds.ServiceNodePortRange.Name: services.KubeAPI.ServiceNodePortRange,
@@ -109,53 +125,49 @@ func getServerConfig(fullState *cluster.FullState, nodeName string) (map[string]
 		cmds.ClusterDNS.Name:           services.Kubelet.ClusterDNSServer,
 		cmds.NodeNameFlag.Name:         nodeName,
 	}
-	if len(services.KubeAPI.ExtraArgs) > 0 {
-		argsMap[cmds.ExtraAPIArgs.Name] = mapToString(services.KubeAPI.ExtraArgs)
-	}
-	if len(services.KubeController.ExtraArgs) > 0 {
-		argsMap[cmds.ExtraControllerArgs.Name] = mapToString(services.KubeController.ExtraArgs)
+	setComponentArgs(argsMap, cmds.ExtraAPIArgs.Name, kubeAPIServerComponent, services.KubeAPI.ExtraArgs, k8sVersion, dataDir)
+	setComponentArgs(argsMap, cmds.ExtraControllerArgs.Name, kubeControllerManagerComponent, services.KubeController.ExtraArgs, k8sVersion, dataDir)
+	setComponentArgs(argsMap, cmds.ExtraSchedulerArgs.Name, kubeSchedulerComponent, services.Scheduler.ExtraArgs, k8sVersion, dataDir)
+	setComponentArgs(argsMap, cmds.ExtraKubeletArgs.Name, kubeletComponent, services.Kubelet.ExtraArgs, k8sVersion, dataDir)
+	setComponentArgs(argsMap, cmds.ExtraKubeProxyArgs.Name, kubeProxyComponent, services.Kubeproxy.ExtraArgs, k8sVersion, dataDir)
+
+	migrateNodeConfig(node, argsMap)
+	migrateServiceAccountTokens(fullState, argsMap)
+
+	if err := migrateAuthConfig(rkeConfig, argsMap); err != nil {
+		return nil, err
 	}
-	if len(services.Scheduler.ExtraArgs) > 0 {
-		argsMap[cmds.ExtraSchedulerArgs.Name] = mapToString(services.Scheduler.ExtraArgs)
+
+	if err := migrateEtcdConfig(services.Etcd, control, k8sVersion, dataDir, argsMap); err != nil {
+		return nil, err
 	}
-	if len(services.Kubelet.ExtraArgs) > 0 {
-		argsMap[cmds.ExtraKubeletArgs.Name] = mapToString(services.Kubelet.ExtraArgs)
+
+	if disabled := disabledComponents(rkeConfig); len(disabled) > 0 {
+		argsMap[disableFlag] = disabled
 	}
 
-	// copy the network cni plugin except for weave as its not yet supported by RKE2
-	networkPlugin := fullState.CurrentState.RancherKubernetesEngineConfig.Network.Plugin
-	if networkPlugin != "" && networkPlugin != weaveCNI {
-		argsMap["cni"] = networkPlugin
+	// plugins not supported by RKE2 are kept running with the none cni
+	if cni := TargetCNI(rkeConfig.Network.Plugin, flannelTarget); cni != "" {
+		argsMap["cni"] = cni
 	}
 
 	return argsMap, nil
 }
 
-func getAgentConfig(fullState *cluster.FullState, nodeName string) (map[string]string, error) {
-	services := fullState.CurrentState.RancherKubernetesEngineConfig.Services
+func getAgentConfig(fullState *cluster.FullState, node *types.RKEConfigNode, nodeName, dataDir, k8sVersion string) (map[string]interface{}, error) {
+	rkeConfig := fullState.CurrentState.RancherKubernetesEngineConfig
+	services := rkeConfig.Services
 
-	argsMap := map[string]string{
+	argsMap := map[string]interface{}{
 		cmds.NodeNameFlag.Name: nodeName,
 	}
-	if len(services.Kubelet.ExtraArgs) > 0 {
-		argsMap[cmds.ExtraKubeletArgs.Name] = mapToString(services.Kubelet.ExtraArgs)
-	}
+	setComponentArgs(argsMap, cmds.ExtraKubeletArgs.Name, kubeletComponent, services.Kubelet.ExtraArgs, k8sVersion, dataDir)
+	setComponentArgs(argsMap, cmds.ExtraKubeProxyArgs.Name, kubeProxyComponent, services.Kubeproxy.ExtraArgs, k8sVersion, dataDir)
+	migrateNodeConfig(node, argsMap)
 
 	return argsMap, nil
 }
 
-func mapToString(args map[string]string) string {
-	argsJoined := ""
-	for k, v := range args {
-		if v == "" {
-			argsJoined += k + ","
-		} else {
-			argsJoined += k + "=" + v + ","
-		}
-	}
-	return strings.TrimSuffix(argsJoined, ",")
-}
-
 func ExportKubeProxyConfig(fullState *cluster.FullState, dataDir string) error {
 	kubeProxyCert := fullState.CurrentState.CertificatesBundle[pki.KubeProxyCertName]
 	caCert := fullState.CurrentState.CertificatesBundle[pki.CACertName]
@@ -194,78 +206,3 @@ func ExportKubeProxyConfig(fullState *cluster.FullState, dataDir string) error {
 
 	return nil
 }
-
-func configurePrivateRegistries(ctx context.Context, fullState *cluster.FullState, registriesTLS []string) error {
-	privateRegistryConfig := fullState.CurrentState.RancherKubernetesEngineConfig.PrivateRegistries
-	if len(privateRegistryConfig) <= 0 {
-		return nil
-	}
-	logrus.Infof("Found Private registry configuration, migrating config to %s", privateRegistryPath)
-	if _, err := os.Stat(privateRegistryPath); err != nil {
-		if os.IsNotExist(err) {
-			r := registries.Registry{}
-			r.Configs = make(map[string]registries.RegistryConfig)
-			for _, reg := range privateRegistryConfig {
-				endpoint := reg.URL
-				if endpoint == "" {
-					endpoint = "docker.io"
-				}
-				if reg.User != "" && reg.Password != "" {
-					r.Configs[endpoint] = registries.RegistryConfig{
-						Auth: &registries.AuthConfig{
-							Username: reg.User,
-							Password: reg.Password,
-						},
-						TLS: getRegistryTLSConfig(endpoint, registriesTLS),
-					}
-				}
-			}
-
-			regBytes, err := yaml.Marshal(r)
-			if err != nil {
-				return err
-			}
-
-			// create a config.d file to add cluster config
-			if err := os.MkdirAll(filepath.Dir(privateRegistryPath), 0755); err != nil {
-				return err
-			}
-
-			if err := os.WriteFile(privateRegistryPath, regBytes, 0600); err != nil {
-				return err
-			}
-		}
-	}
-
-	return nil
-}
-
-func getRegistryTLSConfig(endpoint string, registriesTLS []string) *registries.TLSConfig {
-	if len(registriesTLS) <= 0 {
-		return nil
-	}
-	var caCert, cert, key, u string
-	for _, registryTLS := range registriesTLS {
-		certs := strings.Split(registryTLS, ",")
-		if len(certs) < registryFlagParts {
-			continue
-		}
-		u = certs[0]
-		if u != endpoint {
-			continue
-		}
-		// validating registry url
-		if _, err := url.ParseRequestURI(u); err != nil {
-			logrus.Warnf("registry url %s is invalid", u)
-			continue
-		}
-		caCert = certs[1]
-		cert = certs[2]
-		key = certs[3]
-	}
-	return &registries.TLSConfig{
-		CAFile:   caCert,
-		CertFile: cert,
-		KeyFile:  key,
-	}
-}
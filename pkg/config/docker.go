@@ -0,0 +1,167 @@
+package config
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/rancher/migration-agent/pkg/docker"
+	"github.com/rancher/wharfie/pkg/registries"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	dockerDaemonConfigPath = "/etc/docker/daemon.json"
+	dockerClientConfigPath = "/root/.docker/config.json"
+	dockerHubAuthHost      = "https://index.docker.io/v1/"
+)
+
+// dockerDaemonConfig holds the registry settings of the docker daemon config.
+type dockerDaemonConfig struct {
+	RegistryMirrors    []string `json:"registry-mirrors"`
+	InsecureRegistries []string `json:"insecure-registries"`
+}
+
+// dockerClientConfig holds the registry credentials of the docker client config.
+type dockerClientConfig struct {
+	Auths       map[string]dockerAuth `json:"auths"`
+	CredsStore  string                `json:"credsStore"`
+	CredHelpers map[string]string     `json:"credHelpers"`
+}
+
+type dockerAuth struct {
+	Auth     string `json:"auth"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// importDockerRegistries merges the registry mirrors, insecure registries and
+// pull credentials of the docker daemon on the node into the RKE2 registries,
+// it returns true if anything was imported.
+func importDockerRegistries(r *registries.Registry) (bool, error) {
+	imported := false
+
+	// every RKE node runs docker, missing files mean they are not mounted into
+	// the agent or docker runs with its defaults
+	_, err := os.Stat(docker.SocketPath)
+	dockerNode := err == nil
+
+	daemonConfig := dockerDaemonConfig{}
+	found, err := readJSONFile(dockerDaemonConfigPath, &daemonConfig)
+	if err != nil {
+		return false, err
+	}
+	if !found && dockerNode {
+		logrus.Warnf("Docker daemon config %s not found, no registry mirrors or insecure registries are imported", dockerDaemonConfigPath)
+	}
+	if found {
+		for _, mirror := range daemonConfig.RegistryMirrors {
+			logrus.Infof("Importing docker registry mirror %s from %s", redactURL(mirror), dockerDaemonConfigPath)
+			mergeRegistryMirror(r, defaultRegistry, []string{mirror})
+			imported = true
+		}
+		for _, insecure := range daemonConfig.InsecureRegistries {
+			if _, _, err := net.ParseCIDR(insecure); err == nil {
+				logrus.Warnf("Insecure registry CIDR %s can not be migrated to containerd, add the registries in it to %s manually", insecure, privateRegistryPath)
+				continue
+			}
+			logrus.Infof("Importing docker insecure registry %s from %s", insecure, dockerDaemonConfigPath)
+			// docker falls back to plain http for insecure registries
+			mergeRegistryMirror(r, insecure, []string{registryEndpointURL(insecure), "http://" + insecure})
+			mergeRegistryConfig(r, insecure, registries.RegistryConfig{
+				TLS: &registries.TLSConfig{
+					InsecureSkipVerify: true,
+				},
+			})
+			imported = true
+		}
+	}
+
+	clientConfig := dockerClientConfig{}
+	found, err = readJSONFile(dockerClientConfigPath, &clientConfig)
+	if err != nil {
+		return false, err
+	}
+	if !found && dockerNode {
+		logrus.Warnf("Docker client config %s not found, no registry credentials are imported", dockerClientConfigPath)
+	}
+	if found {
+		if clientConfig.CredsStore != "" || len(clientConfig.CredHelpers) > 0 {
+			logrus.Warnf("Docker credentials stored in credential helpers can not be imported, add them to %s manually", privateRegistryPath)
+		}
+		for host, auth := range clientConfig.Auths {
+			username, password, err := decodeDockerAuth(auth)
+			if err != nil {
+				logrus.Warnf("Failed to decode docker credentials for registry %s, skipping", host)
+				continue
+			}
+			if username == "" || password == "" {
+				continue
+			}
+			host = dockerAuthHost(host)
+			logrus.Infof("Importing docker credentials for registry %s from %s", host, dockerClientConfigPath)
+			mergeRegistryConfig(r, host, registries.RegistryConfig{
+				Auth: &registries.AuthConfig{
+					Username: username,
+					Password: password,
+				},
+			})
+			imported = true
+		}
+	}
+
+	return imported, nil
+}
+
+// readJSONFile decodes the file into obj, it returns false if the file does not exist.
+func readJSONFile(path string, obj interface{}) (bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	if err := json.Unmarshal(data, obj); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func decodeDockerAuth(auth dockerAuth) (string, string, error) {
+	if auth.Auth == "" {
+		return auth.Username, auth.Password, nil
+	}
+	decoded, err := base64.StdEncoding.DecodeString(auth.Auth)
+	if err != nil {
+		return "", "", err
+	}
+	parts := strings.SplitN(string(decoded), ":", 2)
+	if len(parts) != 2 {
+		return "", "", nil
+	}
+	return parts[0], parts[1], nil
+}
+
+// dockerAuthHost converts the docker auth key, which can be a url, to a registry host.
+func dockerAuthHost(host string) string {
+	if host == dockerHubAuthHost {
+		return defaultRegistry
+	}
+	if u, err := url.Parse(host); err == nil && u.Host != "" {
+		return u.Host
+	}
+	return host
+}
+
+// redactURL hides the credentials that can be part of a registry mirror url.
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "[REDACTED]"
+	}
+	return u.Redacted()
+}
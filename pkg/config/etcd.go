@@ -0,0 +1,133 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	daemonconfig "github.com/rancher/k3s/pkg/daemons/config"
+	"github.com/rancher/rke/types"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	etcdArgFlag                  = "etcd-arg"
+	etcdDisableSnapshotsFlag     = "etcd-disable-snapshots"
+	etcdSnapshotScheduleCronFlag = "etcd-snapshot-schedule-cron"
+	etcdSnapshotRetentionFlag    = "etcd-snapshot-retention"
+	etcdS3Flag                   = "etcd-s3"
+	etcdS3EndpointFlag           = "etcd-s3-endpoint"
+	etcdS3EndpointCAFlag         = "etcd-s3-endpoint-ca"
+	etcdS3SkipSSLVerifyFlag      = "etcd-s3-skip-ssl-verify"
+	etcdS3AccessKeyFlag          = "etcd-s3-access-key"
+	etcdS3SecretKeyFlag          = "etcd-s3-secret-key"
+	etcdS3BucketFlag             = "etcd-s3-bucket"
+	etcdS3RegionFlag             = "etcd-s3-region"
+	etcdS3FolderFlag             = "etcd-s3-folder"
+
+	etcdS3CAFile = "etcd-s3-ca.crt"
+)
+
+// migrateEtcdConfig translates the RKE1 etcd service settings into the RKE2
+// etcd snapshot and s3 flags, the s3 settings passed to the agent are used
+// when the RKE state has no s3 backup target.
+func migrateEtcdConfig(etcdService types.ETCDService, control *daemonconfig.Control, k8sVersion, dataDir string, args map[string]interface{}) error {
+	logrus.Infof("Migrating RKE etcd service config")
+	setComponentArgs(args, etcdArgFlag, etcdComponent, etcdService.ExtraArgs, k8sVersion, dataDir)
+
+	if etcdService.UID != 0 || etcdService.GID != 0 {
+		logrus.Warnf("etcd uid %d and gid %d are not migrated, RKE2 only runs etcd as a dedicated user with the cis profile", etcdService.UID, etcdService.GID)
+	}
+
+	var s3Config *types.S3BackupConfig
+	backupConfig := etcdService.BackupConfig
+	if backupConfig != nil {
+		if backupConfig.Enabled != nil && !*backupConfig.Enabled {
+			logrus.Infof("Recurring etcd snapshots are disabled in RKE state")
+			args[etcdDisableSnapshotsFlag] = true
+			return nil
+		}
+		if cron := hoursToCron(backupConfig.IntervalHours); cron != "" {
+			args[etcdSnapshotScheduleCronFlag] = cron
+		}
+		if backupConfig.Retention > 0 {
+			args[etcdSnapshotRetentionFlag] = backupConfig.Retention
+		}
+		s3Config = backupConfig.S3BackupConfig
+	} else if etcdService.Snapshot != nil && *etcdService.Snapshot {
+		// legacy snapshot options are durations instead of intervals and counts
+		creation, err := time.ParseDuration(etcdService.Creation)
+		if err != nil {
+			return fmt.Errorf("invalid etcd snapshot creation period %s: %v", etcdService.Creation, err)
+		}
+		retention, err := time.ParseDuration(etcdService.Retention)
+		if err != nil {
+			return fmt.Errorf("invalid etcd snapshot retention period %s: %v", etcdService.Retention, err)
+		}
+		if cron := hoursToCron(int(creation.Hours())); cron != "" {
+			args[etcdSnapshotScheduleCronFlag] = cron
+		}
+		if creation > 0 && retention >= creation {
+			args[etcdSnapshotRetentionFlag] = int(retention / creation)
+		}
+	}
+
+	if s3Config != nil && s3Config.BucketName != "" {
+		logrus.Infof("Migrating etcd S3 backup target from RKE state")
+		args[etcdS3Flag] = true
+		setIfNotEmpty(args, etcdS3EndpointFlag, s3Config.Endpoint)
+		setIfNotEmpty(args, etcdS3AccessKeyFlag, s3Config.AccessKey)
+		setIfNotEmpty(args, etcdS3SecretKeyFlag, s3Config.SecretKey)
+		setIfNotEmpty(args, etcdS3BucketFlag, s3Config.BucketName)
+		setIfNotEmpty(args, etcdS3RegionFlag, s3Config.Region)
+		setIfNotEmpty(args, etcdS3FolderFlag, s3Config.Folder)
+		if s3Config.CustomCA != "" {
+			// RKE1 stores the CA content while RKE2 expects a path
+			caPath := filepath.Join(dataDir, "server", "tls", etcdS3CAFile)
+			if err := os.MkdirAll(filepath.Dir(caPath), 0700); err != nil {
+				return err
+			}
+			if err := os.WriteFile(caPath, []byte(s3Config.CustomCA), 0600); err != nil {
+				return err
+			}
+			args[etcdS3EndpointCAFlag] = caPath
+		}
+	} else if control != nil && control.EtcdS3BucketName != "" {
+		logrus.Infof("No etcd S3 backup target in RKE state, using the agent S3 settings")
+		args[etcdS3Flag] = true
+		setIfNotEmpty(args, etcdS3EndpointFlag, control.EtcdS3Endpoint)
+		setIfNotEmpty(args, etcdS3EndpointCAFlag, control.EtcdS3EndpointCA)
+		setIfNotEmpty(args, etcdS3AccessKeyFlag, control.EtcdS3AccessKey)
+		setIfNotEmpty(args, etcdS3SecretKeyFlag, control.EtcdS3SecretKey)
+		setIfNotEmpty(args, etcdS3BucketFlag, control.EtcdS3BucketName)
+		setIfNotEmpty(args, etcdS3RegionFlag, control.EtcdS3Region)
+		setIfNotEmpty(args, etcdS3FolderFlag, control.EtcdS3Folder)
+		if control.EtcdS3SkipSSLVerify {
+			args[etcdS3SkipSSLVerifyFlag] = true
+		}
+	}
+
+	return nil
+}
+
+// hoursToCron converts an interval in hours to a cron schedule, intervals of a
+// day or more are rounded down to whole days.
+func hoursToCron(hours int) string {
+	if hours <= 0 {
+		return ""
+	}
+	if hours < 24 {
+		return fmt.Sprintf("0 */%d * * *", hours)
+	}
+	if hours%24 != 0 {
+		logrus.Warnf("etcd snapshot interval of %d hours is rounded down to %d days", hours, hours/24)
+	}
+	return fmt.Sprintf("0 0 */%d * *", hours/24)
+}
+
+func setIfNotEmpty(args map[string]interface{}, flag, value string) {
+	if value != "" {
+		args[flag] = value
+	}
+}
@@ -0,0 +1,183 @@
+package config
+
+import (
+	"strings"
+
+	"github.com/rancher/rke/types"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	pauseImageFlag                 = "pause-image"
+	kubeProxyImageFlag             = "kube-proxy-image"
+	kubeAPIServerImageFlag         = "kube-apiserver-image"
+	kubeControllerManagerImageFlag = "kube-controller-manager-image"
+	kubeSchedulerImageFlag         = "kube-scheduler-image"
+	etcdImageFlag                  = "etcd-image"
+
+	// rancherRepository is the repository of every default RKE1 system image,
+	// images outside of it were replaced by the operator
+	rancherRepository = "rancher/"
+)
+
+// migrateSystemImages translates the RKE1 system_images overrides to the RKE2
+// system-default-registry and image flags.
+func migrateSystemImages(rkeConfig *types.RancherKubernetesEngineConfig, args map[string]interface{}, server bool) {
+	images := rkeConfig.SystemImages
+
+	if registry := systemImagesRegistry(images); registry != "" {
+		if existing, ok := args[systemDefaultRegistryFlag]; !ok {
+			logrus.Infof("RKE system images are pulled from %s, setting %s", registry, systemDefaultRegistryFlag)
+			args[systemDefaultRegistryFlag] = registry
+		} else if existing != registry {
+			logrus.Warnf("RKE system images are pulled from %s but %s is set to %s from the private registries", registry, systemDefaultRegistryFlag, existing)
+		}
+		logrus.Warnf("Manual step required: mirror the RKE2 images of the target release to %s", args[systemDefaultRegistryFlag])
+	}
+
+	// the default RKE1 sandbox image is older than the one shipped with RKE2,
+	// only a pause image replaced by the operator is kept
+	if isCustomImage(images.PodInfraContainer) {
+		args[pauseImageFlag] = images.PodInfraContainer
+	}
+
+	// the hyperkube and etcd images of RKE1 can not run the RKE2 static pods,
+	// only images replaced by the operator are carried over
+	if isCustomImage(images.Kubernetes) {
+		logrus.Warnf("Custom kubernetes image %s is used for the RKE2 components, make sure it is compatible with RKE2", images.Kubernetes)
+		args[kubeProxyImageFlag] = images.Kubernetes
+		if server {
+			args[kubeAPIServerImageFlag] = images.Kubernetes
+			args[kubeControllerManagerImageFlag] = images.Kubernetes
+			args[kubeSchedulerImageFlag] = images.Kubernetes
+		}
+	}
+	if server && isCustomImage(images.Etcd) {
+		logrus.Warnf("Custom etcd image %s is used for RKE2 etcd, make sure it is compatible with RKE2", images.Etcd)
+		args[etcdImageFlag] = images.Etcd
+	}
+	if server {
+		for _, image := range []string{
+			images.CalicoNode,
+			images.CalicoCNI,
+			images.CalicoControllers,
+			images.CanalNode,
+			images.CanalCNI,
+			images.CanalControllers,
+			images.CanalFlannel,
+			images.Flannel,
+			images.FlannelCNI,
+		} {
+			if isCustomImage(image) {
+				logrus.Warnf("Custom CNI image %s can not be migrated to the RKE2 CNI charts, the RKE2 images will be used", image)
+			}
+		}
+	}
+}
+
+// systemImagesRegistry returns the registry shared by the RKE1 system images,
+// an empty registry is returned for docker hub or if the images are spread over
+// several registries.
+func systemImagesRegistry(images types.RKESystemImages) string {
+	var registry string
+	for _, image := range []string{
+		images.Etcd,
+		images.Alpine,
+		images.NginxProxy,
+		images.CertDownloader,
+		images.KubernetesServicesSidecar,
+		images.CoreDNS,
+		images.CoreDNSAutoscaler,
+		images.Kubernetes,
+		images.PodInfraContainer,
+		images.Ingress,
+		images.IngressBackend,
+		images.MetricsServer,
+	} {
+		if image == "" {
+			continue
+		}
+		imageRegistry, _, _ := splitImage(image)
+		if registry == "" {
+			registry = imageRegistry
+		} else if imageRegistry != registry {
+			logrus.Warnf("RKE system images are pulled from several registries, %s is not set", systemDefaultRegistryFlag)
+			return ""
+		}
+	}
+	if registry == defaultRegistry {
+		return ""
+	}
+	return registry
+}
+
+// chartsRegistry returns the system-default-registry RKE2 prefixes the chart
+// images with, matching the one written to the RKE2 config.
+func chartsRegistry(rkeConfig *types.RancherKubernetesEngineConfig) string {
+	for _, reg := range rkeConfig.PrivateRegistries {
+		if reg.IsDefault && reg.URL != "" && reg.URL != defaultRegistry {
+			return reg.URL
+		}
+	}
+	return systemImagesRegistry(rkeConfig.SystemImages)
+}
+
+// chartImage returns the chart image values for an RKE1 system image replaced
+// by the operator, the RKE2 charts prefix the repository with the
+// system-default-registry so it is stripped from the image.
+func chartImage(image, registry string) *ImageConfig {
+	if !isCustomImage(image) {
+		return nil
+	}
+	if strings.Contains(image, "@") {
+		logrus.Warnf("Custom image %s is pinned by digest and can not be migrated to the RKE2 charts", image)
+		return nil
+	}
+	imageRegistry, repository, tag := splitImage(image)
+	if registry == "" {
+		if imageRegistry != defaultRegistry {
+			repository = imageRegistry + "/" + repository
+		}
+	} else if imageRegistry != registry {
+		logrus.Warnf("Custom image %s is not in the system default registry %s and can not be migrated to the RKE2 charts", image, registry)
+		return nil
+	}
+	logrus.Infof("Migrating custom image %s to the RKE2 chart values", image)
+	return &ImageConfig{
+		Repository: repository,
+		Tag:        tag,
+	}
+}
+
+// isCustomImage returns true if the image is not one of the default RKE1 images.
+func isCustomImage(image string) bool {
+	if image == "" {
+		return false
+	}
+	_, repository, _ := splitImage(image)
+	// registries can mirror the rancher images under a project path
+	return !strings.HasPrefix(repository, rancherRepository) && !strings.Contains(repository, "/"+rancherRepository)
+}
+
+// splitImage splits the image reference into its registry, repository and tag
+// or digest, docker.io is returned as registry for docker hub images.
+func splitImage(image string) (string, string, string) {
+	registry := defaultRegistry
+	repository := image
+	if i := strings.Index(image, "/"); i > 0 {
+		host := image[:i]
+		if strings.ContainsAny(host, ".:") || host == "localhost" {
+			registry = host
+			repository = image[i+1:]
+		}
+	}
+	if i := strings.Index(repository, "@"); i >= 0 {
+		return registry, repository[:i], repository[i+1:]
+	}
+	tag := "latest"
+	if i := strings.LastIndex(repository, ":"); i > strings.LastIndex(repository, "/") {
+		tag = repository[i+1:]
+		repository = repository[:i]
+	}
+	return registry, repository, tag
+}
@@ -0,0 +1,38 @@
+package config
+
+import (
+	"github.com/rancher/rke/cluster"
+)
+
+const (
+	kubeProxyModeArg      = "proxy-mode"
+	kubeProxySchedulerArg = "ipvs-scheduler"
+	ipvsProxyMode         = "ipvs"
+)
+
+var ipvsKernelModules = []string{
+	"ip_vs",
+	"ip_vs_rr",
+	"ip_vs_wrr",
+	"ip_vs_sh",
+	"nf_conntrack",
+}
+
+// IsIPVSProxyMode returns true if kube-proxy was configured in ipvs mode by RKE1.
+func IsIPVSProxyMode(fullState *cluster.FullState) bool {
+	return fullState.CurrentState.RancherKubernetesEngineConfig.Services.Kubeproxy.ExtraArgs[kubeProxyModeArg] == ipvsProxyMode
+}
+
+// IPVSKernelModules returns the kernel modules needed by kube-proxy in ipvs
+// mode, including the module of the configured ipvs scheduler.
+func IPVSKernelModules(fullState *cluster.FullState) []string {
+	if !IsIPVSProxyMode(fullState) {
+		return nil
+	}
+	modules := append([]string{}, ipvsKernelModules...)
+	scheduler := fullState.CurrentState.RancherKubernetesEngineConfig.Services.Kubeproxy.ExtraArgs[kubeProxySchedulerArg]
+	if scheduler != "" && scheduler != "rr" && scheduler != "wrr" && scheduler != "sh" {
+		modules = append(modules, "ip_vs_"+scheduler)
+	}
+	return modules
+}
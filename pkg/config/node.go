@@ -0,0 +1,115 @@
+package config
+
+import (
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/rancher/k3s/pkg/cli/cmds"
+	"github.com/rancher/rke/types"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// reservedLabelDomains can only be set by the kubelet on its own node for
+	// the labels in allowedReservedLabels, or are managed by RKE2 itself
+	reservedLabelDomains = []string{
+		"kubernetes.io",
+		"k8s.io",
+		"rke2.io",
+	}
+	allowedReservedLabels = map[string]bool{
+		"kubernetes.io/hostname":                   true,
+		"kubernetes.io/arch":                       true,
+		"kubernetes.io/os":                         true,
+		"beta.kubernetes.io/arch":                  true,
+		"beta.kubernetes.io/os":                    true,
+		"beta.kubernetes.io/instance-type":         true,
+		"node.kubernetes.io/instance-type":         true,
+		"failure-domain.beta.kubernetes.io/region": true,
+		"failure-domain.beta.kubernetes.io/zone":   true,
+		"topology.kubernetes.io/region":            true,
+		"topology.kubernetes.io/zone":              true,
+	}
+	allowedReservedLabelDomains = []string{
+		"kubelet.kubernetes.io",
+		"node.kubernetes.io",
+	}
+)
+
+// migrateNodeConfig adds the labels, taints and addresses of the RKE node to
+// the RKE2 config so they survive the migration.
+func migrateNodeConfig(node *types.RKEConfigNode, args map[string]interface{}) {
+	if node == nil {
+		return
+	}
+	logrus.Infof("Migrating RKE node labels, taints and addresses")
+
+	labels := []string{}
+	keys := make([]string, 0, len(node.Labels))
+	for k := range node.Labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if isReservedLabel(k) {
+			logrus.Warnf("Node label %s uses a prefix reserved by kubernetes or RKE2 and will not be migrated", k)
+			continue
+		}
+		labels = append(labels, k+"="+node.Labels[k])
+	}
+	if len(labels) > 0 {
+		args[cmds.NodeLabels.Name] = labels
+	}
+
+	taints := []string{}
+	for _, taint := range node.Taints {
+		t := taint.Key
+		if taint.Value != "" {
+			t += "=" + taint.Value
+		}
+		taints = append(taints, t+":"+string(taint.Effect))
+	}
+	if len(taints) > 0 {
+		args[cmds.NodeTaints.Name] = taints
+	}
+
+	nodeIP := node.InternalAddress
+	if nodeIP == "" {
+		nodeIP = node.Address
+	}
+	if net.ParseIP(nodeIP) != nil {
+		args[cmds.NodeIPFlag.Name] = nodeIP
+	} else if nodeIP != "" {
+		logrus.Warnf("Node address %s is not an IP address, node-ip will be detected by RKE2", nodeIP)
+	}
+	if node.InternalAddress != "" && node.Address != node.InternalAddress && net.ParseIP(node.Address) != nil {
+		args[cmds.NodeExternalIPFlag.Name] = node.Address
+	}
+}
+
+func isReservedLabel(key string) bool {
+	if allowedReservedLabels[key] {
+		return false
+	}
+	i := strings.Index(key, "/")
+	if i < 0 {
+		return false
+	}
+	domain := key[:i]
+	for _, allowed := range allowedReservedLabelDomains {
+		if domainMatches(domain, allowed) {
+			return false
+		}
+	}
+	for _, reserved := range reservedLabelDomains {
+		if domainMatches(domain, reserved) {
+			return true
+		}
+	}
+	return false
+}
+
+func domainMatches(domain, suffix string) bool {
+	return domain == suffix || strings.HasSuffix(domain, "."+suffix)
+}
@@ -0,0 +1,204 @@
+package config
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rancher/rke/cluster"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	dockerSystemdDropInDir = "/etc/systemd/system/docker.service.d"
+	rke2ServerEnvFile      = "/etc/default/rke2-server"
+	rke2AgentEnvFile       = "/etc/default/rke2-agent"
+
+	httpProxyEnv  = "HTTP_PROXY"
+	httpsProxyEnv = "HTTPS_PROXY"
+	noProxyEnv    = "NO_PROXY"
+)
+
+var proxyEnvs = []string{httpProxyEnv, httpsProxyEnv, noProxyEnv}
+
+// migrateProxyEnv detects the proxy settings of the docker daemon and of the
+// RKE services and writes them to the environment file of the RKE2 service.
+func migrateProxyEnv(fullState *cluster.FullState, server bool) error {
+	rkeConfig := fullState.CurrentState.RancherKubernetesEngineConfig
+	proxyEnv, err := dockerProxyEnv()
+	if err != nil {
+		return err
+	}
+	services := rkeConfig.Services
+	for _, extraEnv := range [][]string{
+		services.Kubelet.ExtraEnv,
+		services.Kubeproxy.ExtraEnv,
+		services.KubeAPI.ExtraEnv,
+		services.KubeController.ExtraEnv,
+		services.Scheduler.ExtraEnv,
+		services.Etcd.ExtraEnv,
+	} {
+		for _, env := range extraEnv {
+			setProxyEnv(proxyEnv, env)
+		}
+	}
+	if proxyEnv[httpProxyEnv] == "" && proxyEnv[httpsProxyEnv] == "" {
+		return nil
+	}
+
+	// cluster traffic must never go through the proxy
+	noProxy := splitList(proxyEnv[noProxyEnv])
+	clusterDomain := services.Kubelet.ClusterDomain
+	if clusterDomain == "" {
+		clusterDomain = "cluster.local"
+	}
+	for _, entry := range []string{
+		"127.0.0.0/8",
+		"localhost",
+		services.KubeController.ClusterCIDR,
+		services.KubeAPI.ServiceClusterIPRange,
+		".svc",
+		"." + clusterDomain,
+	} {
+		if entry != "" && !containsString(noProxy, entry) {
+			noProxy = append(noProxy, entry)
+		}
+	}
+	proxyEnv[noProxyEnv] = strings.Join(noProxy, ",")
+
+	envFile := rke2AgentEnvFile
+	if server {
+		envFile = rke2ServerEnvFile
+	}
+	logrus.Infof("Migrating proxy settings to %s", envFile)
+	return writeEnvFile(envFile, proxyEnv)
+}
+
+// dockerProxyEnv reads the proxy settings from the Environment entries of the
+// docker systemd drop-in files.
+func dockerProxyEnv() (map[string]string, error) {
+	proxyEnv := map[string]string{}
+	dropIns, err := filepath.Glob(filepath.Join(dockerSystemdDropInDir, "*.conf"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(dropIns)
+	for _, dropIn := range dropIns {
+		f, err := os.Open(dropIn)
+		if err != nil {
+			return nil, err
+		}
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if !strings.HasPrefix(line, "Environment=") {
+				continue
+			}
+			for _, env := range splitSystemdEnvironment(strings.TrimPrefix(line, "Environment=")) {
+				setProxyEnv(proxyEnv, env)
+			}
+		}
+		err = scanner.Err()
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return proxyEnv, nil
+}
+
+// splitSystemdEnvironment splits the value of a systemd Environment entry into
+// assignments, assignments can be double quoted to contain spaces.
+func splitSystemdEnvironment(value string) []string {
+	var (
+		envs    []string
+		current strings.Builder
+		quoted  bool
+	)
+	for _, c := range value {
+		switch {
+		case c == '"':
+			quoted = !quoted
+		case c == ' ' && !quoted:
+			if current.Len() > 0 {
+				envs = append(envs, current.String())
+				current.Reset()
+			}
+		default:
+			current.WriteRune(c)
+		}
+	}
+	if current.Len() > 0 {
+		envs = append(envs, current.String())
+	}
+	return envs
+}
+
+// setProxyEnv records the env assignment if it is a proxy setting, lower case
+// proxy variables are treated the same as upper case ones.
+func setProxyEnv(proxyEnv map[string]string, env string) {
+	parts := strings.SplitN(env, "=", 2)
+	if len(parts) != 2 {
+		return
+	}
+	key := strings.ToUpper(strings.TrimSpace(parts[0]))
+	if containsString(proxyEnvs, key) {
+		proxyEnv[key] = strings.TrimSpace(parts[1])
+	}
+}
+
+// writeEnvFile adds the variables to the env file, variables already set in
+// the file are kept.
+func writeEnvFile(path string, env map[string]string) error {
+	var lines []string
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	existing := map[string]bool{}
+	if len(data) > 0 {
+		lines = strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+		for _, line := range lines {
+			if parts := strings.SplitN(line, "=", 2); len(parts) == 2 {
+				existing[strings.TrimSpace(parts[0])] = true
+			}
+		}
+	}
+	for _, key := range proxyEnvs {
+		value, ok := env[key]
+		if !ok || value == "" {
+			continue
+		}
+		if existing[key] {
+			logrus.Infof("%s is already set in %s, keeping it", key, path)
+			continue
+		}
+		lines = append(lines, key+"="+strconv.Quote(value))
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0600)
+}
+
+func splitList(value string) []string {
+	var list []string
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			list = append(list, entry)
+		}
+	}
+	return list
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
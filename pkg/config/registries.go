@@ -0,0 +1,273 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rancher/k3s/pkg/cli/cmds"
+	"github.com/rancher/rke/cluster"
+	"github.com/rancher/rke/types"
+	"github.com/rancher/wharfie/pkg/registries"
+	"github.com/sirupsen/logrus"
+	yamlv3 "gopkg.in/yaml.v3"
+	"k8s.io/apimachinery/pkg/util/version"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	privateRegistryPath       = "/etc/rancher/rke2/registries.yaml"
+	registryFlagParts         = 4
+	defaultRegistry           = "docker.io"
+	systemDefaultRegistryFlag = "system-default-registry"
+
+	imageCredentialProviderConfigPath = "/etc/rancher/rke2/image-credential-provider-config.yaml"
+	imageCredentialProviderBinDir     = "/var/lib/rancher/credentialprovider/bin"
+	ecrCredentialProviderName         = "ecr-credential-provider"
+	credentialProvidersFeatureGate    = "KubeletCredentialProviders=true"
+)
+
+// configurePrivateRegistries merges the RKE1 private registries and the docker
+// registry settings of the node into the RKE2 registries.yaml, entries already
+// present in the file are left untouched.
+func configurePrivateRegistries(ctx context.Context, fullState *cluster.FullState, registriesTLS []string, k8sVersion string, args map[string]interface{}) error {
+	rkeConfig := fullState.CurrentState.RancherKubernetesEngineConfig
+	privateRegistryConfig := rkeConfig.PrivateRegistries
+	r, err := loadRegistries(privateRegistryPath)
+	if err != nil {
+		return err
+	}
+	imported, err := importDockerRegistries(r)
+	if err != nil {
+		return err
+	}
+	if len(privateRegistryConfig) <= 0 {
+		if imported {
+			return writeRegistries(privateRegistryPath, r)
+		}
+		return nil
+	}
+	logrus.Infof("Found Private registry configuration, migrating config to %s", privateRegistryPath)
+	ecrPlugin := mergePrivateRegistries(r, privateRegistryConfig, registriesTLS, args)
+
+	if err := writeRegistries(privateRegistryPath, r); err != nil {
+		return err
+	}
+
+	if ecrPlugin != nil {
+		return configureECRCredentialProvider(ecrPlugin, k8sVersion, args)
+	}
+	return nil
+}
+
+// mergePrivateRegistries adds a mirror and a config for each RKE private
+// registry, and returns the ecr credential plugin of the registries if any.
+func mergePrivateRegistries(r *registries.Registry, privateRegistries []types.PrivateRegistry, registriesTLS []string, args map[string]interface{}) *types.ECRCredentialPlugin {
+	var ecrPlugin *types.ECRCredentialPlugin
+	for _, reg := range privateRegistries {
+		endpoint := reg.URL
+		if endpoint == "" {
+			endpoint = defaultRegistry
+		}
+		if endpoint != defaultRegistry {
+			mergeRegistryMirror(r, endpoint, []string{registryEndpointURL(endpoint)})
+		}
+
+		registryConfig := registries.RegistryConfig{
+			TLS: getRegistryTLSConfig(endpoint, registriesTLS),
+		}
+		if reg.User != "" && reg.Password != "" {
+			registryConfig.Auth = &registries.AuthConfig{
+				Username: reg.User,
+				Password: reg.Password,
+			}
+		}
+		// unauthenticated registries are recorded as well
+		mergeRegistryConfig(r, endpoint, registryConfig)
+
+		if reg.IsDefault && endpoint != defaultRegistry {
+			logrus.Infof("Setting %s to default registry %s", systemDefaultRegistryFlag, endpoint)
+			args[systemDefaultRegistryFlag] = endpoint
+		}
+		if reg.ECRCredentialPlugin != nil {
+			ecrPlugin = reg.ECRCredentialPlugin
+		}
+	}
+	return ecrPlugin
+}
+
+// loadRegistries reads the registries file, an empty configuration is returned
+// if the file does not exist yet.
+func loadRegistries(path string) (*registries.Registry, error) {
+	r := &registries.Registry{}
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		if err := yamlv3.Unmarshal(data, r); err != nil {
+			return nil, err
+		}
+	}
+	if r.Mirrors == nil {
+		r.Mirrors = make(map[string]registries.Mirror)
+	}
+	if r.Configs == nil {
+		r.Configs = make(map[string]registries.RegistryConfig)
+	}
+	return r, nil
+}
+
+func writeRegistries(path string, r *registries.Registry) error {
+	regBytes, err := yamlv3.Marshal(r)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, regBytes, 0600)
+}
+
+// mergeRegistryMirror adds the endpoints to the mirror of the registry host,
+// endpoints already configured are not duplicated.
+func mergeRegistryMirror(r *registries.Registry, host string, endpoints []string) {
+	mirror := r.Mirrors[host]
+	for _, endpoint := range endpoints {
+		found := false
+		for _, existing := range mirror.Endpoints {
+			if existing == endpoint {
+				found = true
+				break
+			}
+		}
+		if !found {
+			mirror.Endpoints = append(mirror.Endpoints, endpoint)
+		}
+	}
+	r.Mirrors[host] = mirror
+}
+
+// mergeRegistryConfig sets the auth and tls config of the registry host unless
+// they are already set in the registries file.
+func mergeRegistryConfig(r *registries.Registry, host string, registryConfig registries.RegistryConfig) {
+	existing := r.Configs[host]
+	if existing.Auth == nil {
+		existing.Auth = registryConfig.Auth
+	} else if registryConfig.Auth != nil {
+		logrus.Infof("Registry %s already has credentials in %s, keeping them", host, privateRegistryPath)
+	}
+	if existing.TLS == nil {
+		existing.TLS = registryConfig.TLS
+	}
+	r.Configs[host] = existing
+}
+
+func registryEndpointURL(host string) string {
+	if strings.HasPrefix(host, "http://") || strings.HasPrefix(host, "https://") {
+		return host
+	}
+	return "https://" + host
+}
+
+func getRegistryTLSConfig(endpoint string, registriesTLS []string) *registries.TLSConfig {
+	if len(registriesTLS) <= 0 {
+		return nil
+	}
+	var caCert, cert, key, u string
+	for _, registryTLS := range registriesTLS {
+		certs := strings.Split(registryTLS, ",")
+		if len(certs) < registryFlagParts {
+			continue
+		}
+		u = certs[0]
+		if u != endpoint {
+			continue
+		}
+		// validating registry url
+		if _, err := url.ParseRequestURI(u); err != nil {
+			logrus.Warnf("registry url %s is invalid", u)
+			continue
+		}
+		caCert = certs[1]
+		cert = certs[2]
+		key = certs[3]
+	}
+	if caCert == "" && cert == "" && key == "" {
+		return nil
+	}
+	return &registries.TLSConfig{
+		CAFile:   caCert,
+		CertFile: cert,
+		KeyFile:  key,
+	}
+}
+
+// configureECRCredentialProvider writes the kubelet image credential provider
+// config for the ecr credential plugin configured in RKE1, the config API
+// version and feature gate depend on the target kubernetes version.
+func configureECRCredentialProvider(ecrPlugin *types.ECRCredentialPlugin, k8sVersion string, args map[string]interface{}) error {
+	logrus.Infof("Migrating ECR credential plugin config to %s", imageCredentialProviderConfigPath)
+	target, err := version.ParseGeneric(k8sVersion)
+	if err != nil {
+		return fmt.Errorf("invalid target kubernetes version %s for the image credential provider config: %v", k8sVersion, err)
+	}
+	// credential providers are alpha before v1.24, beta until v1.26 where the
+	// alpha API is removed, and the feature gate is removed in v1.28
+	apiVersion := "v1alpha1"
+	switch {
+	case target.AtLeast(version.MajorMinor(1, 26)):
+		apiVersion = "v1"
+	case target.AtLeast(version.MajorMinor(1, 24)):
+		apiVersion = "v1beta1"
+	}
+	provider := CredentialProvider{
+		Name: ecrCredentialProviderName,
+		MatchImages: []string{
+			"*.dkr.ecr.*.amazonaws.com",
+			"*.dkr.ecr.*.amazonaws.com.cn",
+		},
+		DefaultCacheDuration: "12h",
+		APIVersion:           "credentialprovider.kubelet.k8s.io/" + apiVersion,
+	}
+	for _, env := range []CredentialProviderEnv{
+		{Name: "AWS_ACCESS_KEY_ID", Value: ecrPlugin.AwsAccessKeyID},
+		{Name: "AWS_SECRET_ACCESS_KEY", Value: ecrPlugin.AwsSecretAccessKey},
+		{Name: "AWS_SESSION_TOKEN", Value: ecrPlugin.AwsSessionToken},
+	} {
+		if env.Value != "" {
+			provider.Env = append(provider.Env, env)
+		}
+	}
+	providerConfig := CredentialProviderConfig{
+		APIVersion: "kubelet.config.k8s.io/" + apiVersion,
+		Kind:       "CredentialProviderConfig",
+		Providers:  []CredentialProvider{provider},
+	}
+	data, err := yaml.Marshal(&providerConfig)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(imageCredentialProviderConfigPath), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(imageCredentialProviderConfigPath, data, 0600); err != nil {
+		return err
+	}
+
+	appendArgs(args, cmds.ExtraKubeletArgs.Name,
+		"image-credential-provider-config="+imageCredentialProviderConfigPath,
+		"image-credential-provider-bin-dir="+imageCredentialProviderBinDir)
+	if target.LessThan(version.MajorMinor(1, 24)) {
+		if hasArg(args, cmds.ExtraKubeletArgs.Name, "feature-gates") {
+			logrus.Warnf("Manual step required: add %s to the kubelet feature-gates", credentialProvidersFeatureGate)
+		} else {
+			appendArgs(args, cmds.ExtraKubeletArgs.Name, "feature-gates="+credentialProvidersFeatureGate)
+		}
+	}
+	logrus.Warnf("Manual step required: install the %s binary to %s on every node", ecrCredentialProviderName, imageCredentialProviderBinDir)
+	return nil
+}
@@ -0,0 +1,130 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/rancher/rke/types"
+	"github.com/rancher/wharfie/pkg/registries"
+)
+
+func TestMergePrivateRegistries(t *testing.T) {
+	tests := []struct {
+		name          string
+		existing      string
+		registries    []types.PrivateRegistry
+		want          *registries.Registry
+		wantArgs      map[string]interface{}
+		wantECRPlugin bool
+	}{
+		{
+			name: "merged into an existing file",
+			existing: `mirrors:
+  docker.io:
+    endpoint:
+    - https://mirror.example.com
+  registry.example.com:
+    endpoint:
+    - https://registry-mirror.example.com
+configs:
+  registry.example.com:
+    auth:
+      username: existing
+      password: secret
+`,
+			registries: []types.PrivateRegistry{
+				{URL: "registry.example.com", User: "rke", Password: "rke-password"},
+				{URL: "new.example.com", User: "rke", Password: "rke-password"},
+			},
+			want: &registries.Registry{
+				Mirrors: map[string]registries.Mirror{
+					"docker.io":            {Endpoints: []string{"https://mirror.example.com"}},
+					"registry.example.com": {Endpoints: []string{"https://registry-mirror.example.com", "https://registry.example.com"}},
+					"new.example.com":      {Endpoints: []string{"https://new.example.com"}},
+				},
+				Configs: map[string]registries.RegistryConfig{
+					"registry.example.com": {
+						Auth: &registries.AuthConfig{Username: "existing", Password: "secret"},
+					},
+					"new.example.com": {
+						Auth: &registries.AuthConfig{Username: "rke", Password: "rke-password"},
+					},
+				},
+			},
+			wantArgs: map[string]interface{}{},
+		},
+		{
+			name: "unauthenticated registry",
+			registries: []types.PrivateRegistry{
+				{URL: "public.example.com"},
+			},
+			want: &registries.Registry{
+				Mirrors: map[string]registries.Mirror{
+					"public.example.com": {Endpoints: []string{"https://public.example.com"}},
+				},
+				Configs: map[string]registries.RegistryConfig{
+					"public.example.com": {},
+				},
+			},
+			wantArgs: map[string]interface{}{},
+		},
+		{
+			name: "default registry",
+			registries: []types.PrivateRegistry{
+				{URL: "", User: "hub", Password: "hub-password"},
+				{URL: "default.example.com", IsDefault: true, ECRCredentialPlugin: &types.ECRCredentialPlugin{AwsAccessKeyID: "key"}},
+			},
+			want: &registries.Registry{
+				Mirrors: map[string]registries.Mirror{
+					"default.example.com": {Endpoints: []string{"https://default.example.com"}},
+				},
+				Configs: map[string]registries.RegistryConfig{
+					"docker.io": {
+						Auth: &registries.AuthConfig{Username: "hub", Password: "hub-password"},
+					},
+					"default.example.com": {},
+				},
+			},
+			wantArgs: map[string]interface{}{
+				systemDefaultRegistryFlag: "default.example.com",
+			},
+			wantECRPlugin: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "registries.yaml")
+			if tt.existing != "" {
+				if err := os.WriteFile(path, []byte(tt.existing), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			r, err := loadRegistries(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			args := map[string]interface{}{}
+			ecrPlugin := mergePrivateRegistries(r, tt.registries, nil, args)
+			if (ecrPlugin != nil) != tt.wantECRPlugin {
+				t.Errorf("ecr plugin = %v, want %v", ecrPlugin, tt.wantECRPlugin)
+			}
+			if !reflect.DeepEqual(args, tt.wantArgs) {
+				t.Errorf("args = %v, want %v", args, tt.wantArgs)
+			}
+
+			// the merged registries are read back the way RKE2 reads them
+			if err := writeRegistries(path, r); err != nil {
+				t.Fatal(err)
+			}
+			written, err := loadRegistries(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(written, tt.want) {
+				t.Errorf("registries = %+v, want %+v", written, tt.want)
+			}
+		})
+	}
+}
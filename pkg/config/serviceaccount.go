@@ -0,0 +1,99 @@
+package config
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/rancher/k3s/pkg/cli/cmds"
+	"github.com/rancher/rke/cluster"
+	"github.com/rancher/rke/pki"
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/util/version"
+)
+
+const (
+	serviceAccountIssuerArg  = "service-account-issuer"
+	serviceAccountKeyFileArg = "service-account-key-file"
+	apiAudiencesArg          = "api-audiences"
+
+	// RKE1 sets its own issuer and audiences starting with kubernetes v1.20,
+	// older clusters only issued legacy tokens which are valid for any issuer
+	rkeServiceAccountIssuer = "rke"
+	rkeAPIAudiences         = "unknown"
+	rkeIssuerMinor          = 20
+
+	rkeServiceAccountKeyFile = "kube-service-account-token-key.pem"
+	rke2Program              = "rke2"
+)
+
+// migrateServiceAccountTokens keeps the service account tokens issued by RKE1
+// valid after the migration. The RKE1 signing key is written to the RKE2
+// service key by the certs migration, which RKE2 signs and verifies tokens
+// with, and the RKE1 issuer and audiences are set on kube-apiserver.
+func migrateServiceAccountTokens(fullState *cluster.FullState, args map[string]interface{}) {
+	rkeConfig := fullState.CurrentState.RancherKubernetesEngineConfig
+	extraArgs := map[string]string{}
+	for k, v := range rkeConfig.Services.KubeAPI.ExtraArgs {
+		extraArgs[strings.TrimLeft(k, "-")] = v
+	}
+
+	if _, ok := fullState.CurrentState.CertificatesBundle[pki.ServiceAccountTokenKeyName]; !ok {
+		logrus.Warnf("No service account token key in RKE state, existing service account tokens will be invalid after the migration")
+	}
+	// only the default RKE1 key is migrated, other verification keys are dropped
+	// with the managed flag
+	if keyFiles := extraArgs[serviceAccountKeyFileArg]; keyFiles != "" {
+		for _, keyFile := range strings.Split(keyFiles, ",") {
+			if filepath.Base(keyFile) != rkeServiceAccountKeyFile {
+				logrus.Warnf("Manual step required: service account tokens verified with %s will be invalid after the migration, reissue them", keyFile)
+			}
+		}
+	}
+
+	issuer := extraArgs[serviceAccountIssuerArg]
+	audiences := extraArgs[apiAudiencesArg]
+	if k8sVersion, err := version.ParseGeneric(rkeConfig.Version); err == nil && k8sVersion.Minor() >= rkeIssuerMinor {
+		if issuer == "" {
+			issuer = rkeServiceAccountIssuer
+		}
+		if audiences == "" {
+			audiences = rkeAPIAudiences
+		}
+	}
+	if issuer == "" {
+		logrus.Infof("RKE cluster only issued legacy service account tokens, keeping the RKE2 service account issuer")
+		return
+	}
+
+	// bound tokens are checked against the issuer and the audiences, the RKE1
+	// issuer is kept for signing and the RKE2 audiences are accepted as well
+	logrus.Infof("Keeping the RKE service account issuer %s", issuer)
+	appendArgs(args, cmds.ExtraAPIArgs.Name, serviceAccountIssuerArg+"="+issuer)
+
+	clusterDomain := rkeConfig.Services.Kubelet.ClusterDomain
+	if clusterDomain == "" {
+		clusterDomain = "cluster.local"
+	}
+	var accepted []string
+	if audiences != "" {
+		accepted = strings.Split(audiences, ",")
+	}
+	for _, audience := range []string{"https://kubernetes.default.svc." + clusterDomain, rke2Program} {
+		if !containsString(accepted, audience) {
+			accepted = append(accepted, audience)
+		}
+	}
+	setArg(args, cmds.ExtraAPIArgs.Name, apiAudiencesArg, strings.Join(accepted, ","))
+}
+
+// setArg sets a flag of a list form RKE2 arg, replacing the existing value.
+func setArg(args map[string]interface{}, flagName, flag, value string) {
+	existing, _ := args[flagName].([]string)
+	for i, arg := range existing {
+		if arg == flag || strings.HasPrefix(arg, flag+"=") {
+			existing[i] = flag + "=" + value
+			return
+		}
+	}
+	appendArgs(args, flagName, flag+"="+value)
+}
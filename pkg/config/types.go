@@ -7,8 +7,10 @@ import (
 )
 
 type CanalConfig struct {
-	Calico  map[string]string `json:"calico,omitempty"`
-	Flannel map[string]string `json:"flannel,omitempty"`
+	PodCIDR        string                          `json:"podCidr,omitempty"`
+	Calico         map[string]string               `json:"calico,omitempty"`
+	Flannel        map[string]string               `json:"flannel,omitempty"`
+	UpdateStrategy *appsv1.DaemonSetUpdateStrategy `json:"updateStrategy,omitempty"`
 }
 
 type CalicoConfig struct {
@@ -16,10 +18,23 @@ type CalicoConfig struct {
 }
 
 type CalicoInstallationSpec struct {
-	// we only copy the mtu config from rke1 so we only need a map of string to integer
-	CalicoNetwork            map[string]int    `json:"calicoNetwork,omitempty"`
-	FlexVolumePath           string            `json:"flexVolumePath,omitempty"`
-	ControlPlaneNodeSelector map[string]string `json:"controlPlaneNodeSelector,omitempty"`
+	CalicoNetwork            CalicoNetworkSpec               `json:"calicoNetwork,omitempty"`
+	FlexVolumePath           string                          `json:"flexVolumePath,omitempty"`
+	ControlPlaneNodeSelector map[string]string               `json:"controlPlaneNodeSelector,omitempty"`
+	ControlPlaneTolerations  []v1.Toleration                 `json:"controlPlaneTolerations,omitempty"`
+	NodeUpdateStrategy       *appsv1.DaemonSetUpdateStrategy `json:"nodeUpdateStrategy,omitempty"`
+}
+
+type CalicoNetworkSpec struct {
+	MTU     int            `json:"mtu,omitempty"`
+	IPPools []CalicoIPPool `json:"ipPools,omitempty"`
+}
+
+type CalicoIPPool struct {
+	CIDR          string `json:"cidr"`
+	Encapsulation string `json:"encapsulation,omitempty"`
+	NATOutgoing   string `json:"natOutgoing,omitempty"`
+	BlockSize     int    `json:"blockSize,omitempty"`
 }
 
 type IngressConfig struct {
@@ -28,27 +43,39 @@ type IngressConfig struct {
 }
 
 type IngressControllerConfig struct {
-	Config            map[string]string               `json:"config,omitempty"`
-	NodeSelector      map[string]string               `json:"nodeSelector,omitempty"`
-	ExtraArgs         map[string]string               `json:"extraArgs,omitempty"`
-	ExtraEnvs         []types.ExtraEnv                `json:"extraEnvs,omitempty"`
-	ExtraVolumes      []types.ExtraVolume             `json:"extraVolumes,omitempty"`
-	ExtraVolumeMounts []types.ExtraVolumeMount        `json:"extraVolumeMounts,omitempty"`
-	DNSPolicy         string                          `json:"dnsPolicy,omitempty"`
-	UpdateStrategy    *appsv1.DaemonSetUpdateStrategy `json:"updateStrategy,omitempty"`
-	HostPorts         IngressHostPorts                `json:"hostPort,omitempty"`
-	HostNetwork       bool                            `json:"HostNetwork,omitempty"`
-	Tolerations       []v1.Toleration                 `json:"tolerations,omitempty"`
-	PriorityClassName string                          `json:"priorityClassName,omitempty"`
+	Config                   map[string]string               `json:"config,omitempty"`
+	NodeSelector             map[string]string               `json:"nodeSelector,omitempty"`
+	ExtraArgs                map[string]string               `json:"extraArgs,omitempty"`
+	ExtraEnvs                []types.ExtraEnv                `json:"extraEnvs,omitempty"`
+	ExtraVolumes             []types.ExtraVolume             `json:"extraVolumes,omitempty"`
+	ExtraVolumeMounts        []types.ExtraVolumeMount        `json:"extraVolumeMounts,omitempty"`
+	DNSPolicy                string                          `json:"dnsPolicy,omitempty"`
+	UpdateStrategy           *appsv1.DaemonSetUpdateStrategy `json:"updateStrategy,omitempty"`
+	HostPorts                IngressHostPorts                `json:"hostPort"`
+	HostNetwork              bool                            `json:"hostNetwork"`
+	Tolerations              []v1.Toleration                 `json:"tolerations,omitempty"`
+	PriorityClassName        string                          `json:"priorityClassName,omitempty"`
+	IngressClass             string                          `json:"ingressClass,omitempty"`
+	IngressClassResource     IngressClassResource            `json:"ingressClassResource"`
+	WatchIngressWithoutClass bool                            `json:"watchIngressWithoutClass"`
+	Image                    *ImageConfig                    `json:"image,omitempty"`
+}
+
+type IngressClassResource struct {
+	Name    string `json:"name,omitempty"`
+	Enabled bool   `json:"enabled"`
+	Default bool   `json:"default"`
 }
 
 type DefaultBackendConfig struct {
-	PriorityClassName string `json:"priorityClassName,omitempty"`
-	Enabled           bool   `json:"enabled,omitempty"`
+	PriorityClassName string       `json:"priorityClassName,omitempty"`
+	Enabled           bool         `json:"enabled"`
+	Image             *ImageConfig `json:"image,omitempty"`
 }
 
 type IngressHostPorts struct {
-	Ports IngressPorts `json:"ports,omitempty"`
+	Enabled bool         `json:"enabled"`
+	Ports   IngressPorts `json:"ports,omitempty"`
 }
 
 type IngressPorts struct {
@@ -63,6 +90,34 @@ type CoreDNSConfig struct {
 	RBAC              RBACConfig                      `json:"rbac,omitempty"`
 	Tolerations       []v1.Toleration                 `json:"tolerations,omitempty"`
 	AutoScalerConfig  AutoScalerConfig                `json:"autoscaler,omitempty"`
+	Servers           []CoreDNSServer                 `json:"servers,omitempty"`
+	NodeLocal         *NodeLocalConfig                `json:"nodelocal,omitempty"`
+	Image             *ImageConfig                    `json:"image,omitempty"`
+}
+
+type CoreDNSServer struct {
+	Zones   []CoreDNSZone   `json:"zones"`
+	Port    int             `json:"port"`
+	Plugins []CoreDNSPlugin `json:"plugins"`
+}
+
+type CoreDNSZone struct {
+	Zone string `json:"zone"`
+}
+
+type CoreDNSPlugin struct {
+	Name        string `json:"name"`
+	Parameters  string `json:"parameters,omitempty"`
+	ConfigBlock string `json:"configBlock,omitempty"`
+}
+
+type NodeLocalConfig struct {
+	Enabled           bool                            `json:"enabled"`
+	IPAddress         string                          `json:"ip_address,omitempty"`
+	IPVS              bool                            `json:"ipvs"`
+	NodeSelector      map[string]string               `json:"nodeSelector,omitempty"`
+	UpdateStrategy    *appsv1.DaemonSetUpdateStrategy `json:"updateStrategy,omitempty"`
+	PriorityClassName string                          `json:"priorityClassName,omitempty"`
 }
 
 type AutoScalerConfig struct {
@@ -74,6 +129,7 @@ type AutoScalerConfig struct {
 	Max                       int             `json:"max,omitempty"`
 	PreventSinglePointFailure bool            `json:"preventSinglePointFailure,omitempty"`
 	Enabled                   bool            `json:"enabled,omitempty"`
+	Image                     *ImageConfig    `json:"image,omitempty"`
 }
 
 type RBACConfig struct {
@@ -87,4 +143,46 @@ type MetricsServerConfig struct {
 	NodeSelector      map[string]string `json:"nodeSelector,omitempty"`
 	Replicas          int               `json:"replicas,omitempty"`
 	Tolerations       []v1.Toleration   `json:"tolerations,omitempty"`
+	Image             *ImageConfig      `json:"image,omitempty"`
+}
+
+type ImageConfig struct {
+	Repository string `json:"repository,omitempty"`
+	Tag        string `json:"tag,omitempty"`
+}
+
+type VsphereCPIConfig struct {
+	VCenter VsphereVCenterConfig `json:"vCenter"`
+}
+
+type VsphereCSIConfig struct {
+	VCenter VsphereVCenterConfig `json:"vCenter"`
+}
+
+type VsphereVCenterConfig struct {
+	Host         string `json:"host"`
+	Port         int    `json:"port,omitempty"`
+	InsecureFlag string `json:"insecureFlag,omitempty"`
+	Datacenters  string `json:"datacenters,omitempty"`
+	Username     string `json:"username,omitempty"`
+	Password     string `json:"password,omitempty"`
+}
+
+type CredentialProviderConfig struct {
+	APIVersion string               `json:"apiVersion"`
+	Kind       string               `json:"kind"`
+	Providers  []CredentialProvider `json:"providers"`
+}
+
+type CredentialProvider struct {
+	Name                 string                  `json:"name"`
+	MatchImages          []string                `json:"matchImages"`
+	DefaultCacheDuration string                  `json:"defaultCacheDuration"`
+	APIVersion           string                  `json:"apiVersion"`
+	Env                  []CredentialProviderEnv `json:"env,omitempty"`
+}
+
+type CredentialProviderEnv struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
 }
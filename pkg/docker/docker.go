@@ -0,0 +1,254 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// SocketPath is the docker engine socket RKE1 runs the containers with.
+	SocketPath = "/var/run/docker.sock"
+
+	// apiVersion is the oldest docker engine API supporting the restart policy
+	// update, every docker version supported by RKE1 serves it
+	apiVersion     = "v1.24"
+	stopTimeout    = 30
+	requestTimeout = 60 * time.Second
+
+	runningState  = "running"
+	restartNever  = "no"
+	containerHost = "docker"
+)
+
+// SystemContainers are the RKE1 system containers in the order they are
+// stopped, the kubelet and kube-proxy first so no pod is restarted, then the
+// control plane and etcd last, and the sidekick the other containers mount.
+var SystemContainers = []string{
+	"kubelet",
+	"kube-proxy",
+	"nginx-proxy",
+	"kube-scheduler",
+	"kube-controller-manager",
+	"kube-apiserver",
+	"etcd-rolling-snapshots",
+	"etcd",
+	"service-sidekick",
+}
+
+// Container is a docker container found on the node.
+type Container struct {
+	ID    string
+	Name  string
+	State string
+}
+
+// Running returns true if the container is running.
+func (c Container) Running() bool {
+	return c.State == runningState
+}
+
+// Client talks to the docker engine API on the local socket.
+type Client struct {
+	client *http.Client
+}
+
+// NewClient returns a client for the docker engine listening on the socket.
+func NewClient(socket string) *Client {
+	dialer := &net.Dialer{}
+	return &Client{
+		client: &http.Client{
+			Timeout: requestTimeout,
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					return dialer.DialContext(ctx, "unix", socket)
+				},
+			},
+		},
+	}
+}
+
+// SystemContainers returns the RKE1 system containers present on the node in
+// the order they are stopped.
+func (c *Client) SystemContainers(ctx context.Context) ([]Container, error) {
+	var list []struct {
+		ID    string   `json:"Id"`
+		Names []string `json:"Names"`
+		State string   `json:"State"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/containers/json", url.Values{"all": []string{"1"}}, nil, &list); err != nil {
+		return nil, err
+	}
+	byName := map[string]Container{}
+	for _, container := range list {
+		for _, name := range container.Names {
+			byName[strings.TrimPrefix(name, "/")] = Container{
+				ID:    container.ID,
+				Name:  strings.TrimPrefix(name, "/"),
+				State: container.State,
+			}
+		}
+	}
+	var containers []Container
+	for _, name := range SystemContainers {
+		if container, ok := byName[name]; ok {
+			containers = append(containers, container)
+		}
+	}
+	return containers, nil
+}
+
+// DisableRestart sets the restart policy of the container to no so docker
+// does not start it again with the daemon.
+func (c *Client) DisableRestart(ctx context.Context, id string) error {
+	update := map[string]interface{}{
+		"RestartPolicy": map[string]interface{}{
+			"Name": restartNever,
+		},
+	}
+	return c.do(ctx, http.MethodPost, "/containers/"+id+"/update", nil, update, nil)
+}
+
+// Stop stops the container, containers already stopped are ignored.
+func (c *Client) Stop(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodPost, "/containers/"+id+"/stop", url.Values{"t": []string{fmt.Sprint(stopTimeout)}}, nil, nil)
+}
+
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	u := url.URL{
+		Scheme:   "http",
+		Host:     containerHost,
+		Path:     "/" + apiVersion + path,
+		RawQuery: query.Encode(),
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// 304 is returned when stopping a stopped container
+	if resp.StatusCode == http.StatusNotModified {
+		return nil
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		apiErr := struct {
+			Message string `json:"message"`
+		}{}
+		data, _ := io.ReadAll(resp.Body)
+		if err := json.Unmarshal(data, &apiErr); err != nil || apiErr.Message == "" {
+			apiErr.Message = strings.TrimSpace(string(data))
+		}
+		return fmt.Errorf("docker %s %s failed with status %d: %s", method, path, resp.StatusCode, apiErr.Message)
+	}
+	if out != nil {
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+	return nil
+}
+
+// StopSystemContainers stops the RKE1 system containers running on the node
+// and disables their restart. Nothing is done if docker is not running, unless
+// the node is part of the RKE cluster and the socket is expected.
+func StopSystemContainers(ctx context.Context, socket string, rkeNode bool) error {
+	if found, err := socketFound(socket, rkeNode); !found {
+		if err == nil {
+			logrus.Infof("Docker socket %s not found, no RKE containers to stop", socket)
+		}
+		return err
+	}
+	client := NewClient(socket)
+	containers, err := client.SystemContainers(ctx)
+	if err != nil {
+		return err
+	}
+	for _, container := range containers {
+		// the restart policy is updated first so the container is not
+		// restarted if the docker daemon restarts meanwhile
+		if err := client.DisableRestart(ctx, container.ID); err != nil {
+			return err
+		}
+		if !container.Running() {
+			continue
+		}
+		logrus.Infof("Stopping RKE container %s", container.Name)
+		if err := client.Stop(ctx, container.ID); err != nil {
+			return err
+		}
+	}
+	return CheckSystemContainers(ctx, socket, rkeNode)
+}
+
+// CheckSystemContainers returns an error if any RKE1 system container is
+// still running on the node, or if the docker socket of an RKE node is missing.
+func CheckSystemContainers(ctx context.Context, socket string, rkeNode bool) error {
+	if found, err := socketFound(socket, rkeNode); !found {
+		return err
+	}
+	containers, err := NewClient(socket).SystemContainers(ctx)
+	if err != nil {
+		return err
+	}
+	var running []string
+	for _, container := range containers {
+		if container.Running() {
+			running = append(running, container.Name)
+		}
+	}
+	if len(running) > 0 {
+		return fmt.Errorf("RKE containers are still running on the node: %s, stop them or pass --stop-rke-containers", strings.Join(running, ", "))
+	}
+	return nil
+}
+
+// CheckSocket returns an error if the docker socket is not a socket, or if it
+// is missing on a node found in the RKE state.
+func CheckSocket(socket string, rkeNode bool) error {
+	_, err := socketFound(socket, rkeNode)
+	return err
+}
+
+// socketFound returns false if the docker socket does not exist, the RKE1
+// containers of a node found in the RKE state can not be checked without it.
+func socketFound(socket string, rkeNode bool) (bool, error) {
+	info, err := os.Stat(socket)
+	if err == nil {
+		// a hostPath mount of a missing socket creates a directory instead
+		if info.Mode()&os.ModeSocket == 0 {
+			return false, fmt.Errorf("docker socket %s is not a socket, mount the docker socket of the node", socket)
+		}
+		return true, nil
+	}
+	if !os.IsNotExist(err) {
+		return false, err
+	}
+	if rkeNode {
+		return false, fmt.Errorf("docker socket %s not found, it must be mounted to check the RKE containers of the node", socket)
+	}
+	return false, nil
+}
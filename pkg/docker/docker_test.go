@@ -0,0 +1,231 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type fakeContainer struct {
+	id    string
+	name  string
+	state string
+	// stopped is true for a container exiting on its own before it is stopped,
+	// the engine answers the stop with 304
+	stopped bool
+}
+
+// fakeEngine serves the docker engine API calls of the client on a unix socket.
+type fakeEngine struct {
+	mu         sync.Mutex
+	containers []*fakeContainer
+	calls      []string
+	policies   map[string]string
+}
+
+func newFakeEngine(t *testing.T, containers ...*fakeContainer) (*fakeEngine, string) {
+	engine := &fakeEngine{containers: containers, policies: map[string]string{}}
+	socket := filepath.Join(t.TempDir(), "docker.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewUnstartedServer(engine)
+	server.Listener = listener
+	server.Start()
+	t.Cleanup(server.Close)
+	return engine, socket
+}
+
+func (e *fakeEngine) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	path := strings.TrimPrefix(r.URL.Path, "/"+apiVersion)
+	if r.Method == http.MethodGet && path == "/containers/json" {
+		if r.URL.Query().Get("all") != "1" {
+			http.Error(w, `{"message":"only running containers listed"}`, http.StatusBadRequest)
+			return
+		}
+		list := []map[string]interface{}{}
+		for _, c := range e.containers {
+			list = append(list, map[string]interface{}{
+				"Id":    c.id,
+				"Names": []string{"/" + c.name},
+				"State": c.state,
+			})
+		}
+		json.NewEncoder(w).Encode(list)
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(path, "/containers/"), "/")
+	if r.Method != http.MethodPost || len(parts) != 2 {
+		http.Error(w, `{"message":"page not found"}`, http.StatusNotFound)
+		return
+	}
+	var container *fakeContainer
+	for _, c := range e.containers {
+		if c.id == parts[0] {
+			container = c
+		}
+	}
+	if container == nil {
+		http.Error(w, `{"message":"No such container: `+parts[0]+`"}`, http.StatusNotFound)
+		return
+	}
+	e.calls = append(e.calls, parts[1]+" "+container.name)
+	switch parts[1] {
+	case "update":
+		update := struct {
+			RestartPolicy struct {
+				Name string
+			}
+		}{}
+		if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+			http.Error(w, `{"message":"invalid update"}`, http.StatusBadRequest)
+			return
+		}
+		e.policies[container.name] = update.RestartPolicy.Name
+		w.Write([]byte(`{"Warnings":[]}`))
+	case "stop":
+		if container.stopped || container.state != runningState {
+			container.state = "exited"
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		container.state = "exited"
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, `{"message":"page not found"}`, http.StatusNotFound)
+	}
+}
+
+func TestSystemContainers(t *testing.T) {
+	_, socket := newFakeEngine(t,
+		&fakeContainer{id: "1", name: "etcd", state: runningState},
+		&fakeContainer{id: "2", name: "k8s_nginx_nginx-ingress", state: runningState},
+		&fakeContainer{id: "3", name: "kube-proxy", state: "exited"},
+		&fakeContainer{id: "4", name: "kubelet", state: runningState},
+	)
+	containers, err := NewClient(socket).SystemContainers(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Container{
+		{ID: "4", Name: "kubelet", State: runningState},
+		{ID: "3", Name: "kube-proxy", State: "exited"},
+		{ID: "1", Name: "etcd", State: runningState},
+	}
+	if !reflect.DeepEqual(containers, want) {
+		t.Errorf("containers = %v, want %v", containers, want)
+	}
+}
+
+func TestStopSystemContainers(t *testing.T) {
+	engine, socket := newFakeEngine(t,
+		&fakeContainer{id: "1", name: "service-sidekick", state: "created"},
+		&fakeContainer{id: "2", name: "etcd", state: runningState},
+		&fakeContainer{id: "3", name: "kube-apiserver", state: runningState, stopped: true},
+		&fakeContainer{id: "4", name: "kubelet", state: runningState},
+		&fakeContainer{id: "5", name: "rancher-agent", state: runningState},
+	)
+	if err := StopSystemContainers(context.Background(), socket, true); err != nil {
+		t.Fatal(err)
+	}
+	wantCalls := []string{
+		"update kubelet",
+		"stop kubelet",
+		"update kube-apiserver",
+		"stop kube-apiserver",
+		"update etcd",
+		"stop etcd",
+		"update service-sidekick",
+	}
+	if !reflect.DeepEqual(engine.calls, wantCalls) {
+		t.Errorf("calls = %v, want %v", engine.calls, wantCalls)
+	}
+	wantPolicies := map[string]string{
+		"kubelet":          restartNever,
+		"kube-apiserver":   restartNever,
+		"etcd":             restartNever,
+		"service-sidekick": restartNever,
+	}
+	if !reflect.DeepEqual(engine.policies, wantPolicies) {
+		t.Errorf("restart policies = %v, want %v", engine.policies, wantPolicies)
+	}
+}
+
+func TestCheckSystemContainers(t *testing.T) {
+	_, socket := newFakeEngine(t,
+		&fakeContainer{id: "1", name: "etcd", state: runningState},
+		&fakeContainer{id: "2", name: "kube-proxy", state: "exited"},
+		&fakeContainer{id: "3", name: "kubelet", state: runningState},
+	)
+	err := CheckSystemContainers(context.Background(), socket, true)
+	if err == nil {
+		t.Fatal("expected an error for the running containers")
+	}
+	if !strings.Contains(err.Error(), "kubelet, etcd") {
+		t.Errorf("error %q does not list the running containers", err)
+	}
+}
+
+func TestSocket(t *testing.T) {
+	dir := t.TempDir()
+	missing := filepath.Join(dir, "missing.sock")
+	// kubelet creates a directory for a hostPath of a missing socket
+	notSocket := filepath.Join(dir, "docker.sock")
+	if err := os.Mkdir(notSocket, 0755); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		socket  string
+		rkeNode bool
+		wantErr bool
+	}{
+		{
+			name:   "missing on a node not in the RKE state",
+			socket: missing,
+		},
+		{
+			name:    "missing on an RKE node",
+			socket:  missing,
+			rkeNode: true,
+			wantErr: true,
+		},
+		{
+			name:    "not a socket",
+			socket:  notSocket,
+			wantErr: true,
+		},
+		{
+			name:    "not a socket on an RKE node",
+			socket:  notSocket,
+			rkeNode: true,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := CheckSocket(tt.socket, tt.rkeNode); (err != nil) != tt.wantErr {
+				t.Errorf("CheckSocket() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err := StopSystemContainers(context.Background(), tt.socket, tt.rkeNode); (err != nil) != tt.wantErr {
+				t.Errorf("StopSystemContainers() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err := CheckSystemContainers(context.Background(), tt.socket, tt.rkeNode); (err != nil) != tt.wantErr {
+				t.Errorf("CheckSystemContainers() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
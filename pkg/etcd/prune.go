@@ -0,0 +1,256 @@
+package etcd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rancher/k3s/pkg/daemons/config"
+	"github.com/sirupsen/logrus"
+	"go.etcd.io/etcd/clientv3"
+	"go.etcd.io/etcd/embed"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime/serializer/protobuf"
+	"k8s.io/client-go/kubernetes/scheme"
+)
+
+const (
+	PruneDeployJobs      = "deploy-jobs"
+	PruneClusterState    = "cluster-state"
+	PruneNodeAnnotations = "node-annotations"
+
+	pruneClientURL   = "http://127.0.0.1:2399"
+	prunePeerURL     = "http://127.0.0.1:2390"
+	pruneMemberName  = "migration-agent-prune"
+	pruneReadTimeout = 60 * time.Second
+
+	jobsPrefix           = "/registry/jobs/kube-system/"
+	podsPrefix           = "/registry/pods/kube-system/"
+	configMapsPrefix     = "/registry/configmaps/kube-system/"
+	nodesPrefix          = "/registry/minions/"
+	nodeLeasesPrefix     = "/registry/leases/kube-node-lease/"
+	csiNodesPrefix       = "/registry/csinodes/"
+	rkeDeployJobPrefix   = "rke-"
+	rkeDeployJobSuffix   = "-deploy-job"
+	rkeAnnotationsPrefix = "rke.cattle.io/"
+)
+
+var (
+	pruneTargets = []string{PruneDeployJobs, PruneClusterState, PruneNodeAnnotations}
+
+	rkeStateConfigMaps = []string{"full-cluster-state", "cluster-state"}
+)
+
+// ValidatePruneTargets checks the RKE1 objects selected for pruning.
+func ValidatePruneTargets(targets []string) error {
+	for _, target := range targets {
+		found := false
+		for _, pruneTarget := range pruneTargets {
+			if target == pruneTarget {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("invalid prune target %s, should be one of %s", target, strings.Join(pruneTargets, ", "))
+		}
+	}
+	return nil
+}
+
+// Prune starts the embedded etcd on the restored data locally and removes the
+// selected RKE1 objects and the nodes that are not migrated, it returns every
+// deleted or rewritten key.
+func Prune(ctx context.Context, control *config.Control, targets, nodes []string) ([]string, error) {
+	if len(targets) == 0 && len(nodes) == 0 {
+		return nil, nil
+	}
+	logrus.Infof("Pruning RKE1 objects from the restored etcd data")
+
+	clientURL, err := url.Parse(pruneClientURL)
+	if err != nil {
+		return nil, err
+	}
+	peerURL, err := url.Parse(prunePeerURL)
+	if err != nil {
+		return nil, err
+	}
+	// the member settings only apply to new data dirs, the restored member is
+	// started listening on localhost only
+	cfg := embed.NewConfig()
+	cfg.Name = pruneMemberName
+	cfg.Dir = filepath.Join(control.DataDir, "db", "etcd")
+	cfg.LCUrls = []url.URL{*clientURL}
+	cfg.ACUrls = []url.URL{*clientURL}
+	cfg.LPUrls = []url.URL{*peerURL}
+	cfg.APUrls = []url.URL{*peerURL}
+	cfg.InitialCluster = pruneMemberName + "=" + prunePeerURL
+	server, err := embed.StartEtcd(cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer server.Close()
+	select {
+	case <-server.Server.ReadyNotify():
+	case <-time.After(pruneReadTimeout):
+		return nil, fmt.Errorf("timed out waiting for the embedded etcd to be ready")
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	client, err := clientv3.New(clientv3.Config{
+		Endpoints:   []string{pruneClientURL},
+		DialTimeout: 10 * time.Second,
+		Context:     ctx,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	var pruned []string
+	for _, target := range targets {
+		var (
+			keys []string
+			err  error
+		)
+		switch target {
+		case PruneDeployJobs:
+			keys, err = pruneDeployJobs(ctx, client)
+		case PruneClusterState:
+			keys, err = pruneClusterState(ctx, client)
+		case PruneNodeAnnotations:
+			keys, err = pruneNodeAnnotations(ctx, client)
+		}
+		if err != nil {
+			return pruned, err
+		}
+		pruned = append(pruned, keys...)
+	}
+	keys, err := pruneNodes(ctx, client, nodes)
+	if err != nil {
+		return pruned, err
+	}
+	pruned = append(pruned, keys...)
+
+	for _, key := range pruned {
+		logrus.Infof("Pruned etcd key %s", key)
+	}
+	logrus.Infof("Pruned %d RKE1 etcd keys", len(pruned))
+	return pruned, nil
+}
+
+// pruneDeployJobs deletes the jobs RKE1 deploys the addons with and their pods.
+func pruneDeployJobs(ctx context.Context, client *clientv3.Client) ([]string, error) {
+	var deleted []string
+	for _, prefix := range []string{jobsPrefix, podsPrefix} {
+		resp, err := client.Get(ctx, prefix, clientv3.WithPrefix(), clientv3.WithKeysOnly())
+		if err != nil {
+			return deleted, err
+		}
+		for _, kv := range resp.Kvs {
+			name := strings.TrimPrefix(string(kv.Key), prefix)
+			if !strings.HasPrefix(name, rkeDeployJobPrefix) {
+				continue
+			}
+			// pods of the jobs are named after the job with a random suffix
+			if (prefix == jobsPrefix && !strings.HasSuffix(name, rkeDeployJobSuffix)) ||
+				(prefix == podsPrefix && !strings.Contains(name, rkeDeployJobSuffix+"-")) {
+				continue
+			}
+			if err := deleteKey(ctx, client, string(kv.Key), &deleted); err != nil {
+				return deleted, err
+			}
+		}
+	}
+	return deleted, nil
+}
+
+// pruneClusterState deletes the ConfigMaps RKE1 stores the cluster state in.
+func pruneClusterState(ctx context.Context, client *clientv3.Client) ([]string, error) {
+	var deleted []string
+	for _, name := range rkeStateConfigMaps {
+		if err := deleteKey(ctx, client, configMapsPrefix+name, &deleted); err != nil {
+			return deleted, err
+		}
+	}
+	return deleted, nil
+}
+
+// pruneNodeAnnotations removes the annotations RKE1 sets on the nodes.
+func pruneNodeAnnotations(ctx context.Context, client *clientv3.Client) ([]string, error) {
+	var rewritten []string
+	resp, err := client.Get(ctx, nodesPrefix, clientv3.WithPrefix())
+	if err != nil {
+		return rewritten, err
+	}
+	serializer := protobuf.NewSerializer(scheme.Scheme, scheme.Scheme)
+	for _, kv := range resp.Kvs {
+		obj, _, err := serializer.Decode(kv.Value, nil, nil)
+		if err != nil {
+			logrus.Warnf("Failed to decode node %s, skipping annotations pruning: %v", kv.Key, err)
+			continue
+		}
+		node, ok := obj.(*v1.Node)
+		if !ok {
+			continue
+		}
+		changed := false
+		for annotation := range node.Annotations {
+			if strings.HasPrefix(annotation, rkeAnnotationsPrefix) {
+				delete(node.Annotations, annotation)
+				changed = true
+			}
+		}
+		if !changed {
+			continue
+		}
+		node.APIVersion = "v1"
+		node.Kind = "Node"
+		buf := &bytes.Buffer{}
+		if err := serializer.Encode(node, buf); err != nil {
+			return rewritten, err
+		}
+		// only write the node if it was not changed in the meantime
+		txn, err := client.Txn(ctx).
+			If(clientv3.Compare(clientv3.ModRevision(string(kv.Key)), "=", kv.ModRevision)).
+			Then(clientv3.OpPut(string(kv.Key), buf.String())).
+			Commit()
+		if err != nil {
+			return rewritten, err
+		}
+		if txn.Succeeded {
+			rewritten = append(rewritten, string(kv.Key))
+		}
+	}
+	return rewritten, nil
+}
+
+// pruneNodes deletes the nodes that are not migrated with their lease and
+// csi node, their pods are garbage collected by RKE2.
+func pruneNodes(ctx context.Context, client *clientv3.Client, nodes []string) ([]string, error) {
+	var deleted []string
+	for _, node := range nodes {
+		for _, prefix := range []string{nodesPrefix, nodeLeasesPrefix, csiNodesPrefix} {
+			if err := deleteKey(ctx, client, prefix+node, &deleted); err != nil {
+				return deleted, err
+			}
+		}
+	}
+	return deleted, nil
+}
+
+func deleteKey(ctx context.Context, client *clientv3.Client, key string, deleted *[]string) error {
+	resp, err := client.Delete(ctx, key)
+	if err != nil {
+		return err
+	}
+	if resp.Deleted > 0 {
+		*deleted = append(*deleted, key)
+	}
+	return nil
+}
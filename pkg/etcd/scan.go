@@ -0,0 +1,227 @@
+package etcd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	migrationconfig "github.com/rancher/migration-agent/pkg/config"
+	bolt "go.etcd.io/bbolt"
+	"go.etcd.io/etcd/mvcc/mvccpb"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer/protobuf"
+	"k8s.io/apimachinery/pkg/util/version"
+	"k8s.io/client-go/kubernetes/scheme"
+)
+
+const (
+	keyBucket                = "key"
+	registryPrefix           = "/registry/"
+	lastAppliedAnnotation    = "kubectl.kubernetes.io/last-applied-configuration"
+	seccompPodAnnotation     = "seccomp.security.alpha.kubernetes.io/pod"
+	seccompDockerDefault     = "docker/default"
+	clusterScopedNamespace   = "(cluster)"
+	revisionTombstoneMarker  = 't'
+	revisionTombstoneKeySize = 18
+)
+
+var (
+	protobufPrefix = []byte("k8s\x00")
+
+	dockerHostPaths = []string{"/var/run/docker.sock", "/run/docker.sock", "/var/lib/docker"}
+)
+
+// Finding is an object of the snapshot that will break or change behaviour
+// on the target kubernetes version.
+type Finding struct {
+	Kind    string
+	Name    string
+	Message string
+}
+
+// Scan opens the etcd snapshot database read-only and reports the stored
+// objects using API versions removed in the target kubernetes version, docker
+// host paths or dockershim only behaviour, the findings are grouped by namespace.
+func Scan(dbPath, k8sVersion string) (map[string][]Finding, error) {
+	target, err := version.ParseGeneric(k8sVersion)
+	if err != nil {
+		return nil, fmt.Errorf("invalid target kubernetes version %s: %v", k8sVersion, err)
+	}
+	db, err := bolt.Open(dbPath, 0400, &bolt.Options{ReadOnly: true, Timeout: 10 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	// the key bucket holds every revision of the keys ordered by revision,
+	// only the latest revision of the keys still present is scanned
+	latest := map[string][]byte{}
+	err = db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(keyBucket))
+		if bucket == nil {
+			return fmt.Errorf("no %s bucket in %s, not an etcd database", keyBucket, dbPath)
+		}
+		return bucket.ForEach(func(revision, value []byte) error {
+			kv := mvccpb.KeyValue{}
+			if err := kv.Unmarshal(value); err != nil {
+				return err
+			}
+			key := string(kv.Key)
+			if len(revision) == revisionTombstoneKeySize && revision[len(revision)-1] == revisionTombstoneMarker {
+				delete(latest, key)
+				return nil
+			}
+			if strings.HasPrefix(key, registryPrefix) {
+				latest[key] = kv.Value
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := protobuf.NewSerializer(scheme.Scheme, scheme.Scheme)
+	findings := map[string][]Finding{}
+	for _, value := range latest {
+		obj, err := decodeObject(decoder, value)
+		if err != nil || obj == nil {
+			continue
+		}
+		namespace, kind, name := objectMeta(obj)
+		if kind == "" {
+			// keys like leases and events of other stores are not objects
+			continue
+		}
+		if namespace == "" {
+			namespace = clusterScopedNamespace
+		}
+		for _, message := range checkObject(obj, kind, target) {
+			findings[namespace] = append(findings[namespace], Finding{
+				Kind:    kind,
+				Name:    name,
+				Message: message,
+			})
+		}
+	}
+	return findings, nil
+}
+
+// decodeObject decodes the stored value to an unstructured object, built-in
+// types are stored as protobuf and custom resources as json.
+func decodeObject(decoder runtime.Decoder, value []byte) (map[string]interface{}, error) {
+	if bytes.HasPrefix(value, protobufPrefix) {
+		typed, gvk, err := decoder.Decode(value, nil, nil)
+		if err != nil || gvk == nil {
+			return nil, err
+		}
+		obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(typed)
+		if err != nil {
+			return nil, err
+		}
+		obj["apiVersion"], obj["kind"] = gvk.ToAPIVersionAndKind()
+		return obj, nil
+	}
+	obj := map[string]interface{}{}
+	if err := json.Unmarshal(value, &obj); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+func checkObject(obj map[string]interface{}, kind string, target *version.Version) []string {
+	var messages []string
+	apiVersion, _ := obj["apiVersion"].(string)
+	if message := removedAPIMessage(apiVersion, kind, target, "is stored as"); message != "" {
+		messages = append(messages, message)
+	}
+	// the version the object was applied with is kept by kubectl apply
+	annotations := nestedMap(obj, "metadata", "annotations")
+	if lastApplied, ok := annotations[lastAppliedAnnotation].(string); ok {
+		applied := struct {
+			APIVersion string `json:"apiVersion"`
+		}{}
+		if err := json.Unmarshal([]byte(lastApplied), &applied); err == nil && applied.APIVersion != apiVersion {
+			if message := removedAPIMessage(applied.APIVersion, kind, target, "was applied as"); message != "" {
+				messages = append(messages, message)
+			}
+		}
+	}
+
+	// pods and workloads created by controllers are reported through their owner
+	if owners, ok := nestedMap(obj, "metadata")["ownerReferences"].([]interface{}); ok && len(owners) > 0 {
+		return messages
+	}
+	podSpec, podAnnotations := podTemplate(obj, kind)
+	if podSpec == nil {
+		return messages
+	}
+	if profile, ok := podAnnotations[seccompPodAnnotation].(string); ok && profile == seccompDockerDefault {
+		messages = append(messages, fmt.Sprintf("uses the %s seccomp profile of dockershim, use runtime/default", seccompDockerDefault))
+	}
+	volumes, _ := podSpec["volumes"].([]interface{})
+	for _, volume := range volumes {
+		path, _ := nestedMap(volume, "hostPath")["path"].(string)
+		for _, dockerPath := range dockerHostPaths {
+			if path == dockerPath || strings.HasPrefix(path, dockerPath+"/") {
+				messages = append(messages, fmt.Sprintf("mounts the docker host path %s which does not exist with containerd", path))
+			}
+		}
+	}
+	for _, containersField := range []string{"initContainers", "containers"} {
+		containers, _ := podSpec[containersField].([]interface{})
+		for _, container := range containers {
+			env, _ := nestedMap(container)["env"].([]interface{})
+			for _, e := range env {
+				if name, _ := nestedMap(e)["name"].(string); name == "DOCKER_HOST" {
+					messages = append(messages, "sets DOCKER_HOST and expects a docker daemon on the node")
+				}
+			}
+		}
+	}
+	return messages
+}
+
+func removedAPIMessage(apiVersion, kind string, target *version.Version, verb string) string {
+	removedIn, replacement, ok := migrationconfig.RemovedAPI(apiVersion, kind)
+	if !ok || target.Minor() < removedIn {
+		return ""
+	}
+	if replacement == "" {
+		return fmt.Sprintf("%s %s which is removed in kubernetes v1.%d without replacement", verb, apiVersion, removedIn)
+	}
+	return fmt.Sprintf("%s %s which is removed in kubernetes v1.%d, use %s", verb, apiVersion, removedIn, replacement)
+}
+
+// podTemplate returns the pod spec and pod annotations of pods and workloads.
+func podTemplate(obj map[string]interface{}, kind string) (map[string]interface{}, map[string]interface{}) {
+	switch kind {
+	case "Pod":
+		return nestedMap(obj, "spec"), nestedMap(obj, "metadata", "annotations")
+	case "Deployment", "DaemonSet", "StatefulSet", "ReplicaSet", "ReplicationController", "Job":
+		return nestedMap(obj, "spec", "template", "spec"), nestedMap(obj, "spec", "template", "metadata", "annotations")
+	case "CronJob":
+		return nestedMap(obj, "spec", "jobTemplate", "spec", "template", "spec"), nestedMap(obj, "spec", "jobTemplate", "spec", "template", "metadata", "annotations")
+	}
+	return nil, nil
+}
+
+func objectMeta(obj map[string]interface{}) (string, string, string) {
+	kind, _ := obj["kind"].(string)
+	metadata := nestedMap(obj, "metadata")
+	namespace, _ := metadata["namespace"].(string)
+	name, _ := metadata["name"].(string)
+	return namespace, kind, name
+}
+
+// nestedMap returns the map found following the fields, nil is returned if a
+// field is missing or is not a map.
+func nestedMap(obj interface{}, fields ...string) map[string]interface{} {
+	m, _ := obj.(map[string]interface{})
+	for _, field := range fields {
+		m, _ = m[field].(map[string]interface{})
+	}
+	return m
+}
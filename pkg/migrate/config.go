@@ -23,4 +23,15 @@ type MigrationConfig struct {
 	DisableNodeSearch        bool
 	ServerNode               bool
 	AgentNode                bool
+	FlannelTarget            string
+	RKE2Version              string
+	AddonsRemovalImage       string
+	AddonsRemovalPullSecrets cli.StringSlice
+	RemoveAddons             cli.StringSlice
+	ClusterDir               string
+	AddonsIncludeCA          string
+	Prune                    cli.StringSlice
+	PruneNodes               cli.StringSlice
+	SkipPreflight            cli.StringSlice
+	StopRKEContainers        bool
 }
@@ -0,0 +1,92 @@
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	etcdmigrate "github.com/rancher/migration-agent/pkg/etcd"
+	"github.com/rancher/migration-agent/pkg/versions"
+)
+
+// Inspect extracts the RKE state from the snapshot and prints the cluster
+// details together with the RKE2 version recommended for the migration and the
+// stored objects that are incompatible with the target RKE2 version.
+func Inspect(ctx context.Context, config *MigrationConfig, out io.Writer) error {
+	k3sConfig, fullState, err := loadSnapshot(ctx, config)
+	if err != nil {
+		return err
+	}
+	rkeConfig := fullState.CurrentState.RancherKubernetesEngineConfig
+
+	fmt.Fprintf(out, "Kubernetes version:\t%s\n", rkeConfig.Version)
+	fmt.Fprintf(out, "Network plugin:\t\t%s\n", rkeConfig.Network.Plugin)
+	fmt.Fprintf(out, "Nodes:\n")
+	for _, node := range rkeConfig.Nodes {
+		name := node.HostnameOverride
+		if name == "" {
+			name = node.Address
+		}
+		fmt.Fprintf(out, "  %s\t%s\t%s\n", name, node.Address, strings.Join(node.Role, ","))
+	}
+
+	recommended, allowed, err := versions.Recommended(rkeConfig.Version)
+	if err != nil {
+		fmt.Fprintf(out, "RKE2 version:\t\tunsupported (%v)\n", err)
+		return nil
+	}
+	fmt.Fprintf(out, "Allowed RKE2 releases:\t%s\n", strings.Join(allowed, ", "))
+	fmt.Fprintf(out, "Recommended RKE2:\t%s\n", recommended)
+
+	rke2Version := config.RKE2Version
+	if rke2Version == "" {
+		if rke2Version, err = versions.DetectRKE2Version(); err != nil {
+			return err
+		}
+	}
+	if rke2Version != "" {
+		status := "compatible"
+		if err := versions.Validate(rkeConfig.Version, rke2Version); err != nil {
+			status = "incompatible"
+		}
+		fmt.Fprintf(out, "Target RKE2:\t\t%s (%s)\n", rke2Version, status)
+	} else {
+		// without a target the snapshot is scanned for the newest allowed
+		// release line, it reports every object a migration could break
+		if rke2Version, err = versions.Newest(rkeConfig.Version); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "Target RKE2:\t\t%s (newest allowed, pass --rke2-version to scan for another target)\n", rke2Version)
+	}
+
+	findings, err := etcdmigrate.Scan(k3sConfig.ClusterResetRestorePath, rke2Version)
+	if err != nil {
+		return err
+	}
+	if len(findings) == 0 {
+		fmt.Fprintf(out, "No incompatible objects found for RKE2 %s\n", rke2Version)
+		return nil
+	}
+	fmt.Fprintf(out, "Incompatible objects for RKE2 %s:\n", rke2Version)
+	namespaces := make([]string, 0, len(findings))
+	for namespace := range findings {
+		namespaces = append(namespaces, namespace)
+	}
+	sort.Strings(namespaces)
+	for _, namespace := range namespaces {
+		fmt.Fprintf(out, "  %s:\n", namespace)
+		nsFindings := findings[namespace]
+		sort.Slice(nsFindings, func(i, j int) bool {
+			if nsFindings[i].Kind != nsFindings[j].Kind {
+				return nsFindings[i].Kind < nsFindings[j].Kind
+			}
+			return nsFindings[i].Name < nsFindings[j].Name
+		})
+		for _, finding := range nsFindings {
+			fmt.Fprintf(out, "    %s %s %s\n", finding.Kind, finding.Name, finding.Message)
+		}
+	}
+	return nil
+}
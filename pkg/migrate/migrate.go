@@ -12,10 +12,14 @@ import (
 	"github.com/rancher/k3s/pkg/etcd"
 	"github.com/rancher/migration-agent/pkg/certs"
 	migrationconfig "github.com/rancher/migration-agent/pkg/config"
+	"github.com/rancher/migration-agent/pkg/docker"
 	etcdmigrate "github.com/rancher/migration-agent/pkg/etcd"
+	"github.com/rancher/migration-agent/pkg/preflight"
+	"github.com/rancher/migration-agent/pkg/versions"
 	"github.com/rancher/rke/cluster"
 	"github.com/rancher/rke/pki"
 	"github.com/rancher/rke/types"
+	corecontrollers "github.com/rancher/wrangler-api/pkg/generated/controllers/core/v1"
 	"github.com/sirupsen/logrus"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -27,6 +31,7 @@ type Agent struct {
 	isControlPlane           bool
 	isWorker                 bool
 	nodeName                 string
+	node                     *types.RKEConfigNode
 	fullState                *cluster.FullState
 	snapshotPath             string
 	dataDir                  string
@@ -37,26 +42,57 @@ type Agent struct {
 	disableAddonsMigrate     bool
 	disableUserAddonsMigrate bool
 	disableCNIMigrate        bool
+	flannelTarget            string
+	addonsRemovalImage       string
+	addonsRemovalPullSecrets []string
+	removeAddons             []string
+	clusterDir               string
+	addonsIncludeCA          string
+	rke2Version              string
+	prune                    []string
+	pruneNodes               []string
+	skipPreflight            []string
+	stopRKEContainers        bool
 }
 
 func (a *Agent) Do(ctx context.Context) error {
+	logrus.Infof("Running preflight checks")
+	results := a.Preflight()
+	logPreflight(results)
+	if err := preflight.Failed(results); err != nil {
+		return err
+	}
+	// without stopping them the RKE1 containers must be gone before anything
+	// is written on the node, or docker must be reachable to stop them later
+	if a.stopRKEContainers {
+		if err := docker.CheckSocket(docker.SocketPath, a.node != nil); err != nil {
+			return err
+		}
+	} else if err := docker.CheckSystemContainers(ctx, docker.SocketPath, a.node != nil); err != nil {
+		return err
+	}
+
 	if a.isControlPlane || a.isETCD {
 		// certificate restoration from rkestate file
 		if err := certs.RecoverCertsFromState(ctx, a.controlConfig, a.fullState); err != nil {
 			return err
 		}
 
-		if err := migrationconfig.ExportClusterConfiguration(ctx, a.fullState, a.nodeName, true, a.registries); err != nil {
+		if err := migrationconfig.ExportClusterConfiguration(ctx, a.fullState, a.controlConfig, a.node, a.nodeName, a.dataDir, a.flannelTarget, a.rke2Version, true, a.registries); err != nil {
 			return err
 		}
 
 		if !a.disableCNIMigrate {
-			if err := migrationconfig.MigrateCNIConfig(ctx, a.fullState, a.dataDir); err != nil {
+			if err := migrationconfig.MigrateCNIConfig(ctx, a.fullState, a.dataDir, a.flannelTarget); err != nil {
 				return err
 			}
 		}
 		// removing old addons and cni
-		if err := migrationconfig.RemoveOldAddons(ctx, a.dataDir); err != nil {
+		var configMaps corecontrollers.ConfigMapController
+		if a.sc != nil {
+			configMaps = a.sc.Core.Core().V1().ConfigMap()
+		}
+		if err := migrationconfig.RemoveOldAddons(ctx, a.fullState, a.dataDir, a.flannelTarget, a.addonsRemovalImage, a.addonsRemovalPullSecrets, a.removeAddons, configMaps); err != nil {
 			return err
 		}
 
@@ -67,11 +103,11 @@ func (a *Agent) Do(ctx context.Context) error {
 		}
 		if !a.disableUserAddonsMigrate {
 			if a.sc == nil {
-				if err := migrationconfig.MigrateUserAddonsConfig(ctx, a.fullState, a.dataDir, nil); err != nil {
+				if err := migrationconfig.MigrateUserAddonsConfig(ctx, a.fullState, a.dataDir, a.clusterDir, a.addonsIncludeCA, a.rke2Version, nil); err != nil {
 					return err
 				}
 			} else {
-				if err := migrationconfig.MigrateUserAddonsConfig(ctx, a.fullState, a.dataDir, a.sc.Core.Core().V1().ConfigMap()); err != nil {
+				if err := migrationconfig.MigrateUserAddonsConfig(ctx, a.fullState, a.dataDir, a.clusterDir, a.addonsIncludeCA, a.rke2Version, a.sc.Core.Core().V1().ConfigMap()); err != nil {
 					return err
 				}
 			}
@@ -79,14 +115,29 @@ func (a *Agent) Do(ctx context.Context) error {
 		}
 	}
 
+	// RKE2 can only start once the RKE1 containers are gone from the node, they
+	// are stopped once the RKE1 API server is no longer needed by the steps above
+	if a.stopRKEContainers {
+		if err := docker.StopSystemContainers(ctx, docker.SocketPath, a.node != nil); err != nil {
+			return err
+		}
+	}
+
 	if a.isETCD && !a.disableETCDRestore {
 		// Do snapshot restore on the node
 		if err := etcdmigrate.Restore(ctx, a.controlConfig, a.fullState.CurrentState.CertificatesBundle[pki.KubeAPICertName]); err != nil {
 			return err
 		}
+		// remove RKE1 objects from the restored data before RKE2 starts
+		if _, err := etcdmigrate.Prune(ctx, a.controlConfig, a.prune, a.pruneNodes); err != nil {
+			return err
+		}
 	}
 
 	if a.isWorker && !(a.isControlPlane || a.isETCD) {
+		if err := migrationconfig.ExportClusterConfiguration(ctx, a.fullState, a.controlConfig, a.node, a.nodeName, a.dataDir, a.flannelTarget, a.rke2Version, false, a.registries); err != nil {
+			return err
+		}
 		// configure kubeproxy pod to work without rke2 installed
 		// by dropping a kubeconfig for kubeproxy
 		if err := migrationconfig.ExportKubeProxyConfig(a.fullState, a.dataDir); err != nil {
@@ -97,36 +148,30 @@ func (a *Agent) Do(ctx context.Context) error {
 }
 
 func New(ctx context.Context, sc *Context, config *MigrationConfig, k8sConn bool) (*Agent, error) {
-	k3sConfig := get(config)
-	snapshotPath := config.Snapshot
-
-	// download s3 config if set
-	if config.EtcdS3BucketName != "" {
-		logrus.Infof("Downloading s3 snapshot")
-		s3, err := etcd.NewS3(ctx, k3sConfig)
-		if err != nil {
-			return nil, err
-		}
-		if err := s3.Download(ctx); err != nil {
-			return nil, err
-		}
-		snapshotPath = filepath.Join(config.DataDir, "server", "db", "snapshots", config.Snapshot)
+	if config.FlannelTarget != "canal" && config.FlannelTarget != "none" {
+		return nil, fmt.Errorf("invalid flannel target %s, should be either canal or none", config.FlannelTarget)
 	}
-	if _, err := os.Stat(snapshotPath); err != nil {
+	if err := migrationconfig.ValidateRemovedAddons(config.RemoveAddons); err != nil {
 		return nil, err
 	}
-
-	// unzip and extract snapshot
-	snapshotDir := filepath.Join(os.TempDir(), fmt.Sprintf("%s%d", decompressedPathPrefix, time.Now().Unix()))
-	logrus.Infof("Extracting snapshot to %s", snapshotDir)
-	snapshot, fullState, err := extractSnapshot(ctx, k3sConfig.ClusterResetRestorePath, snapshotDir)
+	if err := etcdmigrate.ValidatePruneTargets(config.Prune); err != nil {
+		return nil, err
+	}
+	if err := preflight.ValidateSkipped(config.SkipPreflight); err != nil {
+		return nil, err
+	}
+	k3sConfig, fullState, err := loadSnapshot(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+	rke2Version, err := validateRKE2Version(fullState, config.RKE2Version)
 	if err != nil {
 		return nil, err
 	}
-	k3sConfig.ClusterResetRestorePath = snapshot
 	var (
 		worker, etcd, controlplane bool
 		hostnameOverride           string
+		rkeNode                    *types.RKEConfigNode
 	)
 	if config.DisableNodeSearch {
 		if !config.AgentNode && !config.ServerNode {
@@ -165,11 +210,12 @@ func New(ctx context.Context, sc *Context, config *MigrationConfig, k8sConn bool
 			}
 		}
 		hostnameOverride = node.HostnameOverride
+		rkeNode = node
 	}
 
 	return &Agent{
 		fullState:                fullState,
-		snapshotPath:             snapshot,
+		snapshotPath:             k3sConfig.ClusterResetRestorePath,
 		dataDir:                  config.DataDir,
 		controlConfig:            k3sConfig,
 		sc:                       sc,
@@ -177,14 +223,85 @@ func New(ctx context.Context, sc *Context, config *MigrationConfig, k8sConn bool
 		isWorker:                 worker,
 		isControlPlane:           controlplane,
 		nodeName:                 hostnameOverride,
+		node:                     rkeNode,
 		disableETCDRestore:       config.DisableETCDRestore,
 		disableAddonsMigrate:     config.DisableAddonsMigrate,
 		disableUserAddonsMigrate: config.DisableUserAddonsMigrate,
 		disableCNIMigrate:        config.DisableCNIMigrate,
+		flannelTarget:            config.FlannelTarget,
 		registries:               config.RegistriesTLS,
+		addonsRemovalImage:       config.AddonsRemovalImage,
+		addonsRemovalPullSecrets: config.AddonsRemovalPullSecrets,
+		removeAddons:             config.RemoveAddons,
+		clusterDir:               config.ClusterDir,
+		addonsIncludeCA:          config.AddonsIncludeCA,
+		rke2Version:              rke2Version,
+		prune:                    config.Prune,
+		pruneNodes:               config.PruneNodes,
+		skipPreflight:            config.SkipPreflight,
+		stopRKEContainers:        config.StopRKEContainers,
 	}, nil
 }
 
+// loadSnapshot downloads the snapshot from s3 if configured and extracts the
+// etcd snapshot and the RKE state file from it.
+func loadSnapshot(ctx context.Context, config *MigrationConfig) (*config.Control, *cluster.FullState, error) {
+	k3sConfig := get(config)
+	snapshotPath := config.Snapshot
+
+	// download s3 config if set
+	if config.EtcdS3BucketName != "" {
+		logrus.Infof("Downloading s3 snapshot")
+		s3, err := etcd.NewS3(ctx, k3sConfig)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := s3.Download(ctx); err != nil {
+			return nil, nil, err
+		}
+		snapshotPath = filepath.Join(config.DataDir, "server", "db", "snapshots", config.Snapshot)
+	}
+	if _, err := os.Stat(snapshotPath); err != nil {
+		return nil, nil, err
+	}
+
+	// unzip and extract snapshot
+	snapshotDir := filepath.Join(os.TempDir(), fmt.Sprintf("%s%d", decompressedPathPrefix, time.Now().Unix()))
+	logrus.Infof("Extracting snapshot to %s", snapshotDir)
+	snapshot, fullState, err := extractSnapshot(ctx, k3sConfig.ClusterResetRestorePath, snapshotDir)
+	if err != nil {
+		return nil, nil, err
+	}
+	k3sConfig.ClusterResetRestorePath = snapshot
+	return k3sConfig, fullState, nil
+}
+
+// validateRKE2Version checks the RKE2 version passed to the agent, or the one
+// installed on the node, against the kubernetes version of the snapshot and
+// returns it. Without a target version the check only warns.
+func validateRKE2Version(fullState *cluster.FullState, rke2Version string) (string, error) {
+	rkeVersion := fullState.CurrentState.RancherKubernetesEngineConfig.Version
+	if rke2Version == "" {
+		detected, err := versions.DetectRKE2Version()
+		if err != nil {
+			return "", err
+		}
+		rke2Version = detected
+	}
+	if rke2Version == "" {
+		recommended, _, err := versions.Recommended(rkeVersion)
+		if err != nil {
+			// nothing is restored by RKE2 yet, the version is checked once it is known
+			logrus.Warnf("No RKE2 version is known for the migration: %v", err)
+			return "", nil
+		}
+		logrus.Warnf("RKE2 is not installed and no RKE2 version was passed, install RKE2 %s to restore the snapshot of kubernetes %s", recommended, rkeVersion)
+		return "", nil
+	}
+	logrus.Infof("Validating RKE2 version %s against kubernetes version %s", rke2Version, rkeVersion)
+	return rke2Version, versions.Validate(rkeVersion, rke2Version)
+}
+
 func get(mConfig *MigrationConfig) *config.Control {
 	return &config.Control{
 		EtcdS3Endpoint:          mConfig.EtcdS3Endpoint,
@@ -193,6 +310,7 @@ func get(mConfig *MigrationConfig) *config.Control {
 		EtcdS3SecretKey:         mConfig.EtcdS3SecretKey,
 		EtcdS3SkipSSLVerify:     mConfig.EtcdS3SkipSSLVerify,
 		EtcdS3BucketName:        mConfig.EtcdS3BucketName,
+		EtcdS3Region:            mConfig.EtcdS3Region,
 		EtcdS3Folder:            mConfig.EtcdS3Folder,
 		ClusterResetRestorePath: mConfig.Snapshot,
 		DataDir:                 filepath.Join(mConfig.DataDir, "server"),
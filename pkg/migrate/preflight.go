@@ -0,0 +1,72 @@
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	migrationconfig "github.com/rancher/migration-agent/pkg/config"
+	"github.com/rancher/migration-agent/pkg/preflight"
+	"github.com/sirupsen/logrus"
+)
+
+// Preflight prints the results of the checks of the node readiness for RKE2,
+// an error is returned if any check failed. The snapshot is neither downloaded
+// nor extracted, the node is checked for the server ports unless it is passed
+// as an agent and the size of a local snapshot is used for the disk space.
+func Preflight(ctx context.Context, config *MigrationConfig, out io.Writer) error {
+	if err := preflight.ValidateSkipped(config.SkipPreflight); err != nil {
+		return err
+	}
+	var snapshotSize int64
+	if config.EtcdS3BucketName == "" && !config.DisableETCDRestore && !config.AgentNode {
+		info, err := os.Stat(config.Snapshot)
+		if err != nil {
+			return err
+		}
+		snapshotSize = info.Size()
+	}
+	ports := preflight.ServerPorts
+	if config.AgentNode {
+		ports = nil
+	}
+	// the kube-proxy mode is only known from the RKE state in the snapshot, the
+	// ipvs modules are checked by the migration itself
+	results := preflight.Run(config.DataDir, snapshotSize, ports, nil, config.SkipPreflight)
+	for _, result := range results {
+		fmt.Fprintf(out, "%-4s\t%-14s\t%s\n", result.Status, result.ID, result.Message)
+	}
+	return preflight.Failed(results)
+}
+
+// Preflight checks that the node is ready to run RKE2 with the roles it has in
+// the RKE cluster, before the migration writes anything on the node.
+func (a *Agent) Preflight() []preflight.Result {
+	var (
+		snapshotSize int64
+		ports        []int
+	)
+	if a.isETCD && !a.disableETCDRestore {
+		if info, err := os.Stat(a.snapshotPath); err == nil {
+			snapshotSize = info.Size()
+		}
+	}
+	if a.isControlPlane || a.isETCD {
+		ports = preflight.ServerPorts
+	}
+	return preflight.Run(a.dataDir, snapshotSize, ports, migrationconfig.IPVSKernelModules(a.fullState), a.skipPreflight)
+}
+
+func logPreflight(results []preflight.Result) {
+	for _, result := range results {
+		switch result.Status {
+		case preflight.StatusPass, preflight.StatusSkip:
+			logrus.Infof("Preflight check %s: %s", result.ID, result.Message)
+		case preflight.StatusWarn:
+			logrus.Warnf("Preflight check %s: %s", result.ID, result.Message)
+		case preflight.StatusFail:
+			logrus.Errorf("Preflight check %s failed: %s", result.ID, result.Message)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package preflight
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+const (
+	etcRancherDir   = "/etc/rancher"
+	rke2ProcessName = "rke2"
+	// accessWriteOK is the W_OK mode of access(2)
+	accessWriteOK = 0x2
+
+	// the restore writes the snapshot to the etcd data dir and grows the wal
+	// next to it, twice the snapshot size is required to be on the safe side
+	diskSpaceFactor = 2
+)
+
+var (
+	procSwaps    = "/proc/swaps"
+	procCgroups  = "/proc/cgroups"
+	procSys      = "/proc/sys"
+	cgroupV2Root = "/sys/fs/cgroup/cgroup.controllers"
+
+	requiredCgroupControllers = []string{"cpu", "cpuset", "memory", "pids"}
+
+	// sysctls expected by kube-proxy and the RKE2 CNI plugins
+	requiredSysctls = map[string]string{
+		"net.ipv4.ip_forward":                 "1",
+		"net.bridge.bridge-nf-call-iptables":  "1",
+		"net.bridge.bridge-nf-call-ip6tables": "1",
+	}
+)
+
+func checkDiskSpace(dataDir string, snapshotSize int64) Result {
+	if snapshotSize == 0 {
+		return pass("no etcd data is restored on the node")
+	}
+	// the data dir is created by the migration, check its closest parent
+	dir := dataDir
+	for {
+		if _, err := os.Stat(dir); err == nil || dir == filepath.Dir(dir) {
+			break
+		}
+		dir = filepath.Dir(dir)
+	}
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return warn("failed to get the free disk space of %s: %v", dir, err)
+	}
+	free := int64(stat.Bavail) * int64(stat.Bsize)
+	if free < snapshotSize {
+		return fail("%d MiB free in %s, the snapshot needs %d MiB", free>>20, dir, snapshotSize>>20)
+	}
+	if free < diskSpaceFactor*snapshotSize {
+		return warn("%d MiB free in %s, the restored etcd data may need up to %d MiB", free>>20, dir, diskSpaceFactor*snapshotSize>>20)
+	}
+	return pass("%d MiB free in %s for a %d MiB snapshot", free>>20, dir, snapshotSize>>20)
+}
+
+func checkRKE2Data(dataDir string) Result {
+	etcdDir := filepath.Join(dataDir, "server", "db", "etcd")
+	if _, err := os.Stat(etcdDir); err == nil {
+		return fail("RKE2 etcd data found in %s, the node already ran RKE2", etcdDir)
+	}
+	agentDir := filepath.Join(dataDir, "agent")
+	if _, err := os.Stat(agentDir); err == nil {
+		return warn("RKE2 agent data found in %s, the node already ran RKE2", agentDir)
+	}
+	return pass("no RKE2 data in %s", dataDir)
+}
+
+func checkRKE2Process() Result {
+	procs, err := processes()
+	if err != nil {
+		return warn("failed to list the processes: %v", err)
+	}
+	for pid, name := range procs {
+		if name == rke2ProcessName {
+			return fail("rke2 is running with pid %d, stop it before the migration", pid)
+		}
+	}
+	return pass("rke2 is not running")
+}
+
+// checkSwap warns about swap, the RKE1 kubelet ignores it while the RKE2 kubelet
+// refuses to start unless fail-swap-on is disabled.
+func checkSwap() Result {
+	f, err := os.Open(procSwaps)
+	if err != nil {
+		return warn("failed to read %s: %v", procSwaps, err)
+	}
+	defer f.Close()
+	var devices []string
+	scanner := bufio.NewScanner(f)
+	// the first line is the header
+	for scanner.Scan() {
+		if fields := strings.Fields(scanner.Text()); len(fields) > 0 && fields[0] != "Filename" {
+			devices = append(devices, fields[0])
+		}
+	}
+	if len(devices) > 0 {
+		return warn("swap is enabled on %s, the kubelet will not start unless fail-swap-on=false is set", strings.Join(devices, ", "))
+	}
+	return pass("swap is disabled")
+}
+
+func checkCgroups() Result {
+	enabled := map[string]bool{}
+	version := "v1"
+	if data, err := os.ReadFile(cgroupV2Root); err == nil {
+		version = "v2"
+		for _, controller := range strings.Fields(string(data)) {
+			enabled[controller] = true
+		}
+	} else {
+		f, err := os.Open(procCgroups)
+		if err != nil {
+			return warn("failed to read %s: %v", procCgroups, err)
+		}
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			// subsys_name hierarchy num_cgroups enabled
+			fields := strings.Fields(scanner.Text())
+			if len(fields) == 4 && fields[3] == "1" {
+				enabled[fields[0]] = true
+			}
+		}
+	}
+	var missing []string
+	for _, controller := range requiredCgroupControllers {
+		if !enabled[controller] {
+			missing = append(missing, controller)
+		}
+	}
+	if len(missing) > 0 {
+		return fail("cgroup %s controllers are not enabled: %s", version, strings.Join(missing, ", "))
+	}
+	return pass("cgroup %s with the required controllers", version)
+}
+
+func checkKernelModules(modules []string) Result {
+	missing, err := MissingKernelModules(append(append([]string{}, rke2KernelModules...), modules...))
+	if err != nil {
+		return warn("failed to list the kernel modules: %v", err)
+	}
+	if len(missing) > 0 {
+		return fail("kernel modules are not loadable: %s", strings.Join(missing, ", "))
+	}
+	return pass("required kernel modules are available")
+}
+
+func checkSysctls() Result {
+	var wrong []string
+	for _, name := range sortedKeys(requiredSysctls) {
+		data, err := os.ReadFile(filepath.Join(procSys, strings.ReplaceAll(name, ".", "/")))
+		if err != nil {
+			// the bridge sysctls only exist once br_netfilter is loaded by RKE2
+			if os.IsNotExist(err) && strings.HasPrefix(name, "net.bridge.") {
+				continue
+			}
+			wrong = append(wrong, name+" is not readable")
+			continue
+		}
+		if value := strings.TrimSpace(string(data)); value != requiredSysctls[name] {
+			wrong = append(wrong, name+"="+value)
+		}
+	}
+	if len(wrong) > 0 {
+		return warn("sysctls are not set as expected by kube-proxy and the CNI: %s", strings.Join(wrong, ", "))
+	}
+	return pass("required sysctls are set")
+}
+
+func checkEtcRancher() Result {
+	// the check must not change the host, the closest existing parent of the
+	// rke2 config dir is checked for write access instead
+	dir := filepath.Join(etcRancherDir, rke2ProcessName)
+	for {
+		if _, err := os.Stat(dir); err == nil {
+			break
+		} else if !os.IsNotExist(err) {
+			return fail("failed to check %s: %v", dir, err)
+		}
+		dir = filepath.Dir(dir)
+	}
+	if err := syscall.Access(dir, accessWriteOK); err != nil {
+		return fail("%s is not writable: %v", dir, err)
+	}
+	return pass("%s is writable", dir)
+}
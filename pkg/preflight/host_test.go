@@ -0,0 +1,146 @@
+package preflight
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckSysctls(t *testing.T) {
+	tests := []struct {
+		name       string
+		files      map[string]string
+		wantStatus Status
+	}{
+		{
+			name: "all set",
+			files: map[string]string{
+				"net/ipv4/ip_forward":                 "1\n",
+				"net/bridge/bridge-nf-call-iptables":  "1\n",
+				"net/bridge/bridge-nf-call-ip6tables": "1\n",
+			},
+			wantStatus: StatusPass,
+		},
+		{
+			name: "bridge sysctls missing before br_netfilter is loaded",
+			files: map[string]string{
+				"net/ipv4/ip_forward": "1\n",
+			},
+			wantStatus: StatusPass,
+		},
+		{
+			name: "forwarding disabled",
+			files: map[string]string{
+				"net/ipv4/ip_forward":                 "0\n",
+				"net/bridge/bridge-nf-call-iptables":  "1\n",
+				"net/bridge/bridge-nf-call-ip6tables": "1\n",
+			},
+			wantStatus: StatusWarn,
+		},
+		{
+			name:       "forwarding sysctl missing",
+			files:      map[string]string{},
+			wantStatus: StatusWarn,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setPath(t, &procSys, writeFiles(t, tt.files))
+			if result := checkSysctls(); result.Status != tt.wantStatus {
+				t.Errorf("checkSysctls() = %+v, want %s", result, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestCheckCgroups(t *testing.T) {
+	const cgroupsHeader = "#subsys_name\thierarchy\tnum_cgroups\tenabled\n"
+	tests := []struct {
+		name       string
+		files      map[string]string
+		wantStatus Status
+	}{
+		{
+			name: "v2 with the required controllers",
+			files: map[string]string{
+				"cgroup.controllers": "cpuset cpu io memory hugetlb pids rdma misc\n",
+			},
+			wantStatus: StatusPass,
+		},
+		{
+			name: "v2 without the cpuset controller",
+			files: map[string]string{
+				"cgroup.controllers": "cpu io memory pids\n",
+			},
+			wantStatus: StatusFail,
+		},
+		{
+			name: "v1 with the required controllers",
+			files: map[string]string{
+				"cgroups": cgroupsHeader + "cpuset\t2\t4\t1\ncpu\t3\t60\t1\ncpuacct\t3\t60\t1\nmemory\t5\t90\t1\npids\t6\t60\t1\n",
+			},
+			wantStatus: StatusPass,
+		},
+		{
+			name: "v1 with the memory controller disabled",
+			files: map[string]string{
+				"cgroups": cgroupsHeader + "cpuset\t2\t4\t1\ncpu\t3\t60\t1\nmemory\t0\t90\t0\npids\t6\t60\t1\n",
+			},
+			wantStatus: StatusFail,
+		},
+		{
+			name:       "no cgroup information",
+			files:      map[string]string{},
+			wantStatus: StatusWarn,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := writeFiles(t, tt.files)
+			setPath(t, &cgroupV2Root, filepath.Join(root, "cgroup.controllers"))
+			setPath(t, &procCgroups, filepath.Join(root, "cgroups"))
+			if result := checkCgroups(); result.Status != tt.wantStatus {
+				t.Errorf("checkCgroups() = %+v, want %s", result, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestCheckSwap(t *testing.T) {
+	const swapsHeader = "Filename\t\t\t\tType\t\tSize\t\tUsed\t\tPriority\n"
+	tests := []struct {
+		name       string
+		swaps      *string
+		wantStatus Status
+	}{
+		{
+			name:       "swap disabled",
+			swaps:      stringPtr(swapsHeader),
+			wantStatus: StatusPass,
+		},
+		{
+			name:       "swap file and partition",
+			swaps:      stringPtr(swapsHeader + "/swap.img\tfile\t2097148\t0\t-2\n/dev/sda2\tpartition\t1048572\t0\t-3\n"),
+			wantStatus: StatusWarn,
+		},
+		{
+			name:       "swaps not readable",
+			wantStatus: StatusWarn,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files := map[string]string{}
+			if tt.swaps != nil {
+				files["swaps"] = *tt.swaps
+			}
+			setPath(t, &procSwaps, filepath.Join(writeFiles(t, files), "swaps"))
+			if result := checkSwap(); result.Status != tt.wantStatus {
+				t.Errorf("checkSwap() = %+v, want %s", result, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func stringPtr(s string) *string {
+	return &s
+}
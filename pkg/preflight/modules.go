@@ -0,0 +1,98 @@
+package preflight
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	procModules   = "/proc/modules"
+	procOSRelease = "/proc/sys/kernel/osrelease"
+	modulesDir    = "/lib/modules"
+)
+
+// rke2KernelModules are loaded by RKE2 on startup for containerd and the CNI.
+var rke2KernelModules = []string{"overlay", "br_netfilter"}
+
+// MissingKernelModules returns the modules that are neither loaded, built into
+// the kernel nor present in the modules dir of the running kernel.
+func MissingKernelModules(modules []string) ([]string, error) {
+	available, err := availableKernelModules()
+	if err != nil {
+		return nil, err
+	}
+	var missing []string
+	for _, module := range modules {
+		if !available[normalizeModuleName(module)] {
+			missing = append(missing, module)
+		}
+	}
+	return missing, nil
+}
+
+func availableKernelModules() (map[string]bool, error) {
+	available := map[string]bool{}
+	// loaded modules have their name as the first field
+	if err := readModuleList(procModules, available, func(line string) string {
+		return strings.Fields(line)[0]
+	}); err != nil {
+		return nil, err
+	}
+
+	release, err := os.ReadFile(procOSRelease)
+	if err != nil {
+		return nil, err
+	}
+	kernelModulesDir := filepath.Join(modulesDir, strings.TrimSpace(string(release)))
+	// without the modules list of the running kernel every module not loaded
+	// yet would be reported missing
+	if _, err := os.Stat(filepath.Join(kernelModulesDir, "modules.dep")); err != nil {
+		return nil, fmt.Errorf("failed to find the modules of the running kernel, make sure %s is mounted: %v", modulesDir, err)
+	}
+	// builtin and loadable modules are listed by their path
+	for _, list := range []string{"modules.builtin", "modules.dep"} {
+		if err := readModuleList(filepath.Join(kernelModulesDir, list), available, func(line string) string {
+			return moduleNameFromPath(strings.SplitN(line, ":", 2)[0])
+		}); err != nil {
+			return nil, err
+		}
+	}
+	return available, nil
+}
+
+func readModuleList(path string, available map[string]bool, name func(string) string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		available[normalizeModuleName(name(line))] = true
+	}
+	return scanner.Err()
+}
+
+func moduleNameFromPath(path string) string {
+	name := filepath.Base(path)
+	if i := strings.Index(name, ".ko"); i >= 0 {
+		name = name[:i]
+	}
+	return name
+}
+
+// normalizeModuleName treats dashes and underscores the same way modprobe does.
+func normalizeModuleName(name string) string {
+	return strings.ReplaceAll(name, "-", "_")
+}
@@ -0,0 +1,68 @@
+package preflight
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestMissingKernelModules(t *testing.T) {
+	tests := []struct {
+		name        string
+		files       map[string]string
+		modules     []string
+		wantMissing []string
+		wantErr     bool
+	}{
+		{
+			name: "loaded, builtin and loadable modules",
+			files: map[string]string{
+				"modules": "overlay 151552 0 - Live 0x0000000000000000\n" +
+					"ip_vs_rr 16384 0 - Live 0x0000000000000000\n",
+				"osrelease": "5.15.0-91-generic\n",
+				"lib/modules/5.15.0-91-generic/modules.builtin": "kernel/net/bridge/br_netfilter.ko\n",
+				"lib/modules/5.15.0-91-generic/modules.dep": "kernel/net/netfilter/ipvs/ip_vs.ko: kernel/net/netfilter/nf_conntrack.ko\n" +
+					"kernel/net/netfilter/ipvs/ip_vs_wrr.ko.zst: kernel/net/netfilter/ipvs/ip_vs.ko\n" +
+					"kernel/net/netfilter/nf_conntrack.ko:\n",
+			},
+			modules:     []string{"overlay", "br_netfilter", "ip_vs", "ip_vs_rr", "ip_vs_wrr", "ip_vs_sh", "nf-conntrack"},
+			wantMissing: []string{"ip_vs_sh"},
+		},
+		{
+			name: "builtin list is optional",
+			files: map[string]string{
+				"modules":                       "",
+				"osrelease":                     "6.1.0\n",
+				"lib/modules/6.1.0/modules.dep": "kernel/fs/overlayfs/overlay.ko:\n",
+			},
+			modules:     []string{"overlay", "br_netfilter"},
+			wantMissing: []string{"br_netfilter"},
+		},
+		{
+			name: "modules dir of the running kernel is not mounted",
+			files: map[string]string{
+				"modules":                        "overlay 151552 0 - Live 0x0000000000000000\n",
+				"osrelease":                      "6.1.0\n",
+				"lib/modules/5.10.0/modules.dep": "kernel/fs/overlayfs/overlay.ko:\n",
+			},
+			modules: []string{"overlay"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := writeFiles(t, tt.files)
+			setPath(t, &procModules, filepath.Join(root, "modules"))
+			setPath(t, &procOSRelease, filepath.Join(root, "osrelease"))
+			setPath(t, &modulesDir, filepath.Join(root, "lib/modules"))
+
+			missing, err := MissingKernelModules(tt.modules)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("MissingKernelModules() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(missing, tt.wantMissing) {
+				t.Errorf("missing = %v, want %v", missing, tt.wantMissing)
+			}
+		})
+	}
+}
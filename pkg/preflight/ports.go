@@ -0,0 +1,156 @@
+package preflight
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const tcpListenState = "0A"
+
+var (
+	procDir = "/proc"
+
+	// ServerPorts are the ports RKE2 servers listen on, supervisor,
+	// kube-apiserver, etcd client and etcd peer.
+	ServerPorts = []int{9345, 6443, 2379, 2380}
+
+	procNetTCP = []string{"/proc/net/tcp", "/proc/net/tcp6"}
+
+	// processes of the RKE1 containers, which are stopped before RKE2 starts
+	rkeProcesses = []string{"etcd", "kube-apiserver", "nginx", "kubelet", "kube-proxy"}
+)
+
+func checkPorts(ports []int) Result {
+	if len(ports) == 0 {
+		return pass("no RKE2 ports to check on the node")
+	}
+	listeners, err := listeningPorts()
+	if err != nil {
+		return warn("failed to list the listening ports: %v", err)
+	}
+	var rkeHeld, otherHeld []string
+	for _, port := range ports {
+		owner, ok := listeners[port]
+		if !ok {
+			continue
+		}
+		held := fmt.Sprintf("%d (%s)", port, owner)
+		if contains(rkeProcesses, owner) {
+			rkeHeld = append(rkeHeld, held)
+		} else {
+			otherHeld = append(otherHeld, held)
+		}
+	}
+	if len(otherHeld) > 0 {
+		return fail("ports are in use: %s", strings.Join(append(otherHeld, rkeHeld...), ", "))
+	}
+	if len(rkeHeld) > 0 {
+		return warn("ports are held by RKE components which must be stopped before RKE2 starts: %s", strings.Join(rkeHeld, ", "))
+	}
+	return pass("ports %s are free", joinPorts(ports))
+}
+
+// listeningPorts returns the tcp ports in the listen state along with the name
+// of the process holding them, or unknown if it can not be found.
+func listeningPorts() (map[int]string, error) {
+	inodes := map[string]int{}
+	for _, path := range procNetTCP {
+		f, err := os.Open(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			// sl local_address rem_address st tx_queue:rx_queue tr:tm->when retrnsmt uid timeout inode
+			fields := strings.Fields(scanner.Text())
+			if len(fields) < 10 || fields[3] != tcpListenState {
+				continue
+			}
+			i := strings.LastIndex(fields[1], ":")
+			if i < 0 {
+				continue
+			}
+			port, err := strconv.ParseInt(fields[1][i+1:], 16, 32)
+			if err != nil {
+				continue
+			}
+			inodes[fields[9]] = int(port)
+		}
+		err = scanner.Err()
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	listeners := map[int]string{}
+	for _, port := range inodes {
+		listeners[port] = "unknown"
+	}
+	procs, err := processes()
+	if err != nil {
+		return listeners, nil
+	}
+	for pid, name := range procs {
+		fds, err := os.ReadDir(filepath.Join(procDir, strconv.Itoa(pid), "fd"))
+		if err != nil {
+			continue
+		}
+		for _, fd := range fds {
+			link, err := os.Readlink(filepath.Join(procDir, strconv.Itoa(pid), "fd", fd.Name()))
+			if err != nil || !strings.HasPrefix(link, "socket:[") {
+				continue
+			}
+			if port, ok := inodes[strings.TrimSuffix(strings.TrimPrefix(link, "socket:["), "]")]; ok {
+				listeners[port] = name
+			}
+		}
+	}
+	return listeners, nil
+}
+
+// processes returns the command names of the running processes by pid.
+func processes() (map[int]string, error) {
+	entries, err := os.ReadDir(procDir)
+	if err != nil {
+		return nil, err
+	}
+	procs := map[int]string{}
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		comm, err := os.ReadFile(filepath.Join(procDir, entry.Name(), "comm"))
+		if err != nil {
+			continue
+		}
+		procs[pid] = strings.TrimSpace(string(comm))
+	}
+	return procs, nil
+}
+
+func joinPorts(ports []int) string {
+	s := make([]string, 0, len(ports))
+	for _, port := range ports {
+		s = append(s, strconv.Itoa(port))
+	}
+	return strings.Join(s, ", ")
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,84 @@
+package preflight
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const procNetTCPHeader = "  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode\n"
+
+func TestListeningPorts(t *testing.T) {
+	root := writeFiles(t, map[string]string{
+		"net/tcp": procNetTCPHeader +
+			// 0.0.0.0:2379 listening
+			"   0: 00000000:094B 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 1001 1 0000000000000000 100 0 0 10 0\n" +
+			// 127.0.0.1:6443 established, not a listener
+			"   1: 0100007F:192B 0100007F:D2A4 01 00000000:00000000 00:00000000 00000000     0        0 1002 1 0000000000000000 20 4 30 10 -1\n" +
+			// truncated line
+			"   2: 00000000:1F90 00000000:0000 0A\n",
+		"net/tcp6": procNetTCPHeader +
+			// [::]:9345 listening
+			"   0: 00000000000000000000000000000000:2481 00000000000000000000000000000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 2001 1 0000000000000000 100 0 0 10 0\n",
+		"123/comm": "etcd\n",
+		"456/comm": "sshd\n",
+	})
+	if err := os.MkdirAll(filepath.Join(root, "123", "fd"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for fd, target := range map[string]string{"3": "socket:[1001]", "4": "/dev/null"} {
+		if err := os.Symlink(target, filepath.Join(root, "123", "fd", fd)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	setPath(t, &procDir, root)
+	old := procNetTCP
+	procNetTCP = []string{filepath.Join(root, "net/tcp"), filepath.Join(root, "net/tcp6"), filepath.Join(root, "net/missing")}
+	t.Cleanup(func() { procNetTCP = old })
+
+	listeners, err := listeningPorts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[int]string{
+		2379: "etcd",
+		9345: "unknown",
+	}
+	if !reflect.DeepEqual(listeners, want) {
+		t.Errorf("listeners = %v, want %v", listeners, want)
+	}
+
+	tests := []struct {
+		name       string
+		ports      []int
+		wantStatus Status
+	}{
+		{
+			name:       "no ports",
+			wantStatus: StatusPass,
+		},
+		{
+			name:       "free ports",
+			ports:      []int{6443, 2380},
+			wantStatus: StatusPass,
+		},
+		{
+			name:       "port held by RKE",
+			ports:      []int{6443, 2379},
+			wantStatus: StatusWarn,
+		},
+		{
+			name:       "port held by another process",
+			ports:      ServerPorts,
+			wantStatus: StatusFail,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if result := checkPorts(tt.ports); result.Status != tt.wantStatus {
+				t.Errorf("checkPorts(%v) = %+v, want %s", tt.ports, result, tt.wantStatus)
+			}
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package preflight
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Status is the outcome of a preflight check.
+type Status string
+
+const (
+	StatusPass Status = "pass"
+	StatusWarn Status = "warn"
+	StatusFail Status = "fail"
+	StatusSkip Status = "skip"
+
+	CheckDiskSpace     = "disk-space"
+	CheckRKE2Data      = "rke2-data"
+	CheckRKE2Process   = "rke2-process"
+	CheckPorts         = "ports"
+	CheckSwap          = "swap"
+	CheckCgroups       = "cgroups"
+	CheckKernelModules = "kernel-modules"
+	CheckSysctls       = "sysctls"
+	CheckEtcRancher    = "etc-rancher"
+)
+
+var checks = []string{
+	CheckDiskSpace,
+	CheckRKE2Data,
+	CheckRKE2Process,
+	CheckPorts,
+	CheckSwap,
+	CheckCgroups,
+	CheckKernelModules,
+	CheckSysctls,
+	CheckEtcRancher,
+}
+
+// Result is the outcome of a single preflight check.
+type Result struct {
+	ID      string
+	Status  Status
+	Message string
+}
+
+// ValidateSkipped checks the ids of the preflight checks to skip.
+func ValidateSkipped(skip []string) error {
+	for _, id := range skip {
+		if !contains(checks, id) {
+			return fmt.Errorf("invalid preflight check %s, should be one of %s", id, strings.Join(checks, ", "))
+		}
+	}
+	return nil
+}
+
+// Run checks that the node is ready to run RKE2. The snapshot size is the size
+// of the etcd data restored on the node, zero if none is restored, and the ports
+// are the ones RKE2 listens on for the roles of the node.
+func Run(dataDir string, snapshotSize int64, ports []int, modules, skip []string) []Result {
+	results := make([]Result, 0, len(checks))
+	for _, id := range checks {
+		if contains(skip, id) {
+			results = append(results, Result{ID: id, Status: StatusSkip, Message: "skipped"})
+			continue
+		}
+		var result Result
+		switch id {
+		case CheckDiskSpace:
+			result = checkDiskSpace(dataDir, snapshotSize)
+		case CheckRKE2Data:
+			result = checkRKE2Data(dataDir)
+		case CheckRKE2Process:
+			result = checkRKE2Process()
+		case CheckPorts:
+			result = checkPorts(ports)
+		case CheckSwap:
+			result = checkSwap()
+		case CheckCgroups:
+			result = checkCgroups()
+		case CheckKernelModules:
+			result = checkKernelModules(modules)
+		case CheckSysctls:
+			result = checkSysctls()
+		case CheckEtcRancher:
+			result = checkEtcRancher()
+		}
+		result.ID = id
+		results = append(results, result)
+	}
+	return results
+}
+
+// Failed returns an error listing the failed checks, nil if none failed.
+func Failed(results []Result) error {
+	var failed []string
+	for _, result := range results {
+		if result.Status == StatusFail {
+			failed = append(failed, result.ID)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("preflight checks failed: %s, fix them or skip them with --skip-preflight", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+func pass(format string, a ...interface{}) Result {
+	return Result{Status: StatusPass, Message: fmt.Sprintf(format, a...)}
+}
+
+func warn(format string, a ...interface{}) Result {
+	return Result{Status: StatusWarn, Message: fmt.Sprintf(format, a...)}
+}
+
+func fail(format string, a ...interface{}) Result {
+	return Result{Status: StatusFail, Message: fmt.Sprintf(format, a...)}
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
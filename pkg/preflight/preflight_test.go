@@ -0,0 +1,78 @@
+package preflight
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeFiles writes the files under a temporary dir standing in for the host
+// root and returns the dir.
+func writeFiles(t *testing.T, files map[string]string) string {
+	root := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+// setPath points a host path read by the checks at the test file.
+func setPath(t *testing.T, path *string, value string) {
+	old := *path
+	*path = value
+	t.Cleanup(func() { *path = old })
+}
+
+func TestValidateSkipped(t *testing.T) {
+	tests := []struct {
+		name    string
+		skip    []string
+		wantErr bool
+	}{
+		{
+			name: "nothing skipped",
+		},
+		{
+			name: "known checks",
+			skip: []string{CheckSwap, CheckKernelModules, CheckEtcRancher},
+		},
+		{
+			name:    "unknown check",
+			skip:    []string{CheckSwap, "selinux"},
+			wantErr: true,
+		},
+		{
+			name:    "check names are case sensitive",
+			skip:    []string{"Ports"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateSkipped(tt.skip); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateSkipped() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRunSkipped(t *testing.T) {
+	results := Run(t.TempDir(), 0, nil, nil, checks)
+	if len(results) != len(checks) {
+		t.Fatalf("got %d results, want %d", len(results), len(checks))
+	}
+	for i, result := range results {
+		if result.ID != checks[i] || result.Status != StatusSkip {
+			t.Errorf("result %d = %+v, want %s skipped", i, result, checks[i])
+		}
+	}
+	if err := Failed(results); err != nil {
+		t.Errorf("Failed() = %v, want nil for skipped checks", err)
+	}
+}
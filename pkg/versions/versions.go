@@ -0,0 +1,130 @@
+package versions
+
+import (
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/version"
+)
+
+// releaseLine describes which RKE2 release lines can restore the etcd snapshot
+// of an RKE1 cluster running a kubernetes minor version.
+type releaseLine struct {
+	// allowed RKE2 minor versions, the same minor or the next one
+	allowed []uint
+	// recommended RKE2 release for the migration
+	recommended string
+}
+
+// compatibilityMatrix maps the RKE1 kubernetes minor versions to the RKE2
+// release lines, RKE2 supports restoring the same minor version and upgrading
+// by one minor version like any kubernetes control plane.
+var compatibilityMatrix = map[uint]releaseLine{
+	18: {allowed: []uint{18, 19}, recommended: "v1.18.20+rke2r1"},
+	19: {allowed: []uint{19, 20}, recommended: "v1.19.16+rke2r1"},
+	20: {allowed: []uint{20, 21}, recommended: "v1.20.15+rke2r2"},
+	21: {allowed: []uint{21, 22}, recommended: "v1.21.14+rke2r1"},
+	22: {allowed: []uint{22, 23}, recommended: "v1.22.17+rke2r1"},
+	23: {allowed: []uint{23, 24}, recommended: "v1.23.17+rke2r1"},
+	24: {allowed: []uint{24, 25}, recommended: "v1.24.17+rke2r1"},
+	25: {allowed: []uint{25, 26}, recommended: "v1.25.16+rke2r1"},
+	26: {allowed: []uint{26, 27}, recommended: "v1.26.15+rke2r1"},
+}
+
+// hostRoot is where the agent DaemonSet mounts the binary dirs of the host.
+const hostRoot = "/host"
+
+var (
+	// the install script puts rke2 in /usr/local/bin, or in /opt/rke2/bin when
+	// /usr/local is read-only, and the rpm in /usr/bin
+	rke2Binaries = []string{
+		"rke2",
+		"/usr/local/bin/rke2",
+		"/opt/rke2/bin/rke2",
+		hostRoot + "/usr/local/bin/rke2",
+		hostRoot + "/opt/rke2/bin/rke2",
+		hostRoot + "/usr/bin/rke2",
+	}
+	rke2VersionRegex = regexp.MustCompile(`rke2 version (v\S+)`)
+)
+
+// Recommended returns the recommended RKE2 version and the allowed RKE2 release
+// lines for the kubernetes version of the RKE1 cluster.
+func Recommended(rkeVersion string) (string, []string, error) {
+	line, err := getReleaseLine(rkeVersion)
+	if err != nil {
+		return "", nil, err
+	}
+	allowed := []string{}
+	for _, minor := range line.allowed {
+		allowed = append(allowed, fmt.Sprintf("v1.%d", minor))
+	}
+	return line.recommended, allowed, nil
+}
+
+// Newest returns the newest RKE2 release line allowed for the kubernetes version
+// of the RKE1 cluster.
+func Newest(rkeVersion string) (string, error) {
+	line, err := getReleaseLine(rkeVersion)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("v1.%d", line.allowed[len(line.allowed)-1]), nil
+}
+
+// Validate checks that the RKE2 version can restore the snapshot of an RKE1
+// cluster running the given kubernetes version.
+func Validate(rkeVersion, rke2Version string) error {
+	line, err := getReleaseLine(rkeVersion)
+	if err != nil {
+		return err
+	}
+	target, err := version.ParseGeneric(rke2Version)
+	if err != nil {
+		return fmt.Errorf("invalid RKE2 version %s: %v", rke2Version, err)
+	}
+	for _, minor := range line.allowed {
+		if target.Major() == 1 && target.Minor() == minor {
+			return nil
+		}
+	}
+	_, allowed, _ := Recommended(rkeVersion)
+	return fmt.Errorf("RKE2 version %s can not restore a snapshot of kubernetes %s, allowed RKE2 release lines are %s (recommended %s)",
+		rke2Version, rkeVersion, strings.Join(allowed, ", "), line.recommended)
+}
+
+// DetectRKE2Version returns the version of the RKE2 binary installed on the
+// node, looking into the host binary dirs mounted in the agent container too,
+// an empty version is returned if RKE2 is not installed.
+func DetectRKE2Version() (string, error) {
+	for _, binary := range rke2Binaries {
+		path, err := exec.LookPath(binary)
+		if err != nil {
+			continue
+		}
+		out, err := exec.Command(path, "--version").Output()
+		if err != nil {
+			return "", fmt.Errorf("failed to get the version of %s: %v", path, err)
+		}
+		matches := rke2VersionRegex.FindStringSubmatch(string(out))
+		if len(matches) < 2 {
+			return "", fmt.Errorf("failed to parse the version of %s", path)
+		}
+		return matches[1], nil
+	}
+	return "", nil
+}
+
+func getReleaseLine(rkeVersion string) (releaseLine, error) {
+	v, err := version.ParseGeneric(rkeVersion)
+	if err != nil {
+		return releaseLine{}, fmt.Errorf("invalid RKE kubernetes version %s: %v", rkeVersion, err)
+	}
+	line, ok := compatibilityMatrix[v.Minor()]
+	if v.Major() != 1 || !ok {
+		return releaseLine{}, fmt.Errorf("kubernetes version %s is not supported for migration to RKE2", rkeVersion)
+	}
+	return line, nil
+}